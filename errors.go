@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/itsfrank/stask/internal/staskfile"
+	"github.com/itsfrank/stask/internal/template"
+)
+
+// exit codes of stask's own errors, listed in "stask help exit"
+// a task that fails makes stask exit with the exit code of the task, these are codes tasks rarely use
+// so scripts running stask can tell a failed task from stask not being able to run it
+const (
+	exitUsage        = 64
+	exitInvalid      = 65
+	exitNotFound     = 66
+	exitTemplate     = 67
+	exitMissingState = 68
+	exitShell        = 69
+	exitInternal     = 70
+	// the command of a task could not be started, like a shell reports a command it cannot run
+	exitCannotRun = 127
+	// a task killed by a signal exits with this plus the signal number, like a shell reports it
+	exitSignalBase = 128
+	// "stask lint --strict" found warnings
+	exitLintWarnings = 1
+)
+
+// an error that stops stask, reported by exitWithError and exiting with the code of its kind
+// errors that are not an exitError are unexpected, they exit with exitInternal
+type exitError interface {
+	error
+	exitCode() int
+}
+
+// the staskfile to read does not exist
+type notFoundError struct {
+	path string
+}
+
+func (err *notFoundError) Error() string {
+	return fmt.Sprintf("no staskfile at '%s', run `stask init` to create one", err.path)
+}
+
+func (err *notFoundError) exitCode() int {
+	return exitNotFound
+}
+
+// a staskfile exists but cannot be used: a syntax error, a value of the wrong type, a newer version,
+// an include or a dependency that cannot be resolved
+type invalidError struct {
+	err error
+}
+
+func (err *invalidError) Error() string {
+	var parseErr *staskfile.ParseError
+	if errors.As(err.err, &parseErr) {
+		return fmt.Sprintf("could not parse staskfile '%s': %s", parseErr.Path, strings.TrimSpace(err.err.Error()))
+	}
+	return err.err.Error()
+}
+
+func (err *invalidError) Unwrap() error {
+	return err.err
+}
+
+func (err *invalidError) exitCode() int {
+	return exitInvalid
+}
+
+// a string of a task is not a valid template, or state cannot be applied to it
+type templateError struct {
+	task string
+	err  error
+}
+
+func (err *templateError) Error() string {
+	// parse errors name the task themselves, and show where the template is wrong
+	if parseErr, ok := err.err.(*template.ParseError); ok {
+		return parseErr.Diagnostic()
+	}
+	return fmt.Sprintf("task '%s': %v", err.task, err.err)
+}
+
+func (err *templateError) Unwrap() error {
+	return err.err
+}
+
+func (err *templateError) exitCode() int {
+	return exitTemplate
+}
+
+// a task uses keys that are not in state or the environment, and have no default value
+type missingStateError struct {
+	task string
+	keys []string
+}
+
+func (err *missingStateError) Error() string {
+	return fmt.Sprintf("task '%s' uses keys not found in state or environment: %s\n"+
+		"    set them with \"stask set <key> <value>\", or for one run with \"stask run %s <key>=<value>\"",
+		err.task, strings.Join(err.keys, ", "), err.task)
+}
+
+func (err *missingStateError) exitCode() int {
+	return exitMissingState
+}
+
+// how to run tasks is not configured, or configured wrong
+type shellError struct {
+	message string
+}
+
+func (err *shellError) Error() string {
+	return err.message + "\n    see \"stask help shell\""
+}
+
+func (err *shellError) exitCode() int {
+	return exitShell
+}
+
+// a task ran and failed, stask exits with its exit code
+type taskFailedError struct {
+	task string
+	code int
+}
+
+func (err *taskFailedError) Error() string {
+	return fmt.Sprintf("task '%s' failed with exit code %d", err.task, err.code)
+}
+
+func (err *taskFailedError) exitCode() int {
+	return err.code
+}
+
+// prints the error and exits with the exit code of its kind
+func exitWithError(err error) {
+	code := exitInternal
+	var exitErr exitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.exitCode()
+	}
+
+	var taskErr *taskFailedError
+	if errors.As(err, &taskErr) {
+		// the task printed why it failed
+		fmt.Fprintln(flag.CommandLine.Output(), "stask:", err)
+	} else {
+		fmt.Fprintln(flag.CommandLine.Output(), "error:", err)
+	}
+	os.Exit(code)
+}
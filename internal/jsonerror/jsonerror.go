@@ -3,10 +3,28 @@ package jsonerror
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 )
 
+// an error at a position of a document, its message includes the position
+type PositionError struct {
+	// counted from 1, Character is 0 when only the line is known
+	Line      int
+	Character int
+	Message   string
+	// the error found at the position, if it is not only described by the message
+	Err error
+}
+
+func (err *PositionError) Error() string {
+	return err.Message
+}
+
+func (err *PositionError) Unwrap() error {
+	return err.Err
+}
+
+// returns json.SyntaxError and json.UnmarshalTypeError as a *PositionError, other errors are returned as they are
 func GetFormattedError(jsonString string, err error) error {
 
 	if jsonError, ok := err.(*json.SyntaxError); ok {
@@ -15,14 +33,14 @@ func GetFormattedError(jsonString string, err error) error {
 		if lcErr != nil {
 			return err
 		}
-		return errors.New(fmt.Sprintf("syntax error - line %d, character %d: %v\n", line, character, jsonError.Error()))
+		return &PositionError{line, character, fmt.Sprintf("syntax error - line %d, character %d: %v\n", line, character, jsonError.Error()), nil}
 	}
 	if jsonError, ok := err.(*json.UnmarshalTypeError); ok {
 		line, character, lcErr := lineAndCharacter(jsonString, int(jsonError.Offset))
 		if lcErr != nil {
 			return err
 		}
-		return errors.New(fmt.Sprintf("json type '%v' cannot be converted into go '%v' type - line %d, character %d\n", jsonError.Value, jsonError.Type.Name(), line, character))
+		return &PositionError{line, character, fmt.Sprintf("json type '%v' cannot be converted into go '%v' type - line %d, character %d\n", jsonError.Value, jsonError.Type.Name(), line, character), nil}
 	}
 
 	return err
@@ -0,0 +1,54 @@
+package prefixwriter
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// writes every line of output with a prefix, so the output of several processes can be told apart
+// writers sharing a mutex never interleave their lines, partial lines are held until they are complete
+type Writer struct {
+	out    io.Writer
+	prefix []byte
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+func New(out io.Writer, prefix string, mu *sync.Mutex) *Writer {
+	return &Writer{out: out, prefix: []byte(prefix), mu: mu}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	end := bytes.LastIndexByte(w.buf, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+
+	lines := w.buf[:end+1]
+	var out bytes.Buffer
+	for len(lines) > 0 {
+		i := bytes.IndexByte(lines, '\n')
+		out.Write(w.prefix)
+		out.Write(lines[:i+1])
+		lines = lines[i+1:]
+	}
+	w.buf = append(w.buf[:0], w.buf[end+1:]...)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.out.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writes the last line if it did not end with a newline
+func (w *Writer) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.Write([]byte{'\n'})
+	return err
+}
@@ -0,0 +1,67 @@
+package prefixwriter_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/itsfrank/stask/internal/prefixwriter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var tests = []struct {
+		name   string
+		writes []string
+		result string
+	}{
+		{"single line", []string{"hello\n"}, "[a] hello\n"},
+		{"many lines", []string{"hello\nworld\n"}, "[a] hello\n[a] world\n"},
+		{"partial lines", []string{"hel", "lo\nwor", "ld\n"}, "[a] hello\n[a] world\n"},
+		{"empty line", []string{"\n"}, "[a] \n"},
+		{"unterminated", []string{"hello\nworld"}, "[a] hello\n[a] world\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := prefixwriter.New(&out, "[a] ", &sync.Mutex{})
+			for _, write := range tt.writes {
+				n, err := w.Write([]byte(write))
+				assert.Nil(t, err)
+				assert.Equal(t, len(write), n)
+			}
+			assert.Nil(t, w.Flush())
+			assert.Equal(t, tt.result, out.String())
+		})
+	}
+}
+
+func TestPrefixWriterConcurrent(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := prefixwriter.New(&out, fmt.Sprintf("[%d] ", i), &mu)
+			for j := 0; j < 100; j++ {
+				// split each line in two writes so lines are only complete in the buffer
+				fmt.Fprintf(w, "line %d ", j)
+				fmt.Fprintf(w, "of writer %d\n", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.Len(t, lines, 800)
+	for _, line := range lines {
+		var prefix, writer, j int
+		_, err := fmt.Sscanf(line, "[%d] line %d of writer %d", &prefix, &j, &writer)
+		assert.Nil(t, err, line)
+		assert.Equal(t, prefix, writer, line)
+	}
+}
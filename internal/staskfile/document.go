@@ -0,0 +1,449 @@
+package staskfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// a value of a staskfile along with where it is written, the same for every format
+// Line and Column are 0 when the format does not keep positions
+type docNode struct {
+	Line   int
+	Column int
+	// nodeScalar, nodeObject or nodeArray
+	Kind    int
+	Members []docMember
+	Items   []*docNode
+	// the value of a scalar, as decoded from JSON
+	Value interface{}
+	// the position of each rune of a string, nil when they cannot be found in the source, see runePosition
+	Runes []docPosition
+}
+
+type docPosition struct {
+	Line   int
+	Column int
+}
+
+type docMember struct {
+	Key string
+	// the position of the key
+	Line   int
+	Column int
+	Value  *docNode
+}
+
+const (
+	nodeScalar = iota
+	nodeObject
+	nodeArray
+)
+
+// returns the node as the value encoding/json would decode it into an interface{}
+func (node *docNode) value() interface{} {
+	switch node.Kind {
+	case nodeObject:
+		object := map[string]interface{}{}
+		for _, member := range node.Members {
+			object[member.Key] = member.Value.value()
+		}
+		return object
+	case nodeArray:
+		array := []interface{}{}
+		for _, item := range node.Items {
+			array = append(array, item.value())
+		}
+		return array
+	}
+	return node.Value
+}
+
+// returns the value of a string scalar, empty for other nodes
+func (node *docNode) str() string {
+	str, _ := node.Value.(string)
+	return str
+}
+
+// returns the node at a path of keys and indexes separated by dots, like the Field of a json.UnmarshalTypeError
+// the deepest node found is returned when the path goes further than the document
+func (node *docNode) find(path string) *docNode {
+	for _, part := range strings.Split(path, ".") {
+		next := node.member(part)
+		if index, err := strconv.Atoi(part); err == nil && node.Kind == nodeArray && index < len(node.Items) {
+			next = node.Items[index]
+		}
+		if next == nil {
+			return node
+		}
+		node = next
+	}
+	return node
+}
+
+// returns the value of a key of an object, matched without case like encoding/json does when it is not found as is
+func (node *docNode) member(key string) *docNode {
+	var folded *docNode
+	for _, member := range node.Members {
+		if member.Key == key {
+			return member.Value
+		}
+		if folded == nil && strings.EqualFold(member.Key, key) {
+			folded = member.Value
+		}
+	}
+	return folded
+}
+
+// decodes the task written at node, an error is returned along with the node it is about
+// errors of values of the wrong type name the field of the task rather than the Go type it is decoded into
+func decodeTask(node *docNode) (Task, *docNode, error) {
+	var task Task
+	err := node.decode(&task)
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		return task, node, err
+	}
+
+	parts := strings.Split(typeErr.Field, ".")
+	field := parts[0]
+	for _, part := range parts[1:] {
+		if index, err := strconv.Atoi(part); err == nil {
+			field = fmt.Sprintf("%s item %d", field, index+1)
+		} else {
+			field = fmt.Sprintf("%s '%s'", field, part)
+		}
+	}
+	return task, node.find(typeErr.Field), fmt.Errorf("%s must be %s", field, describeType(typeErr.Type))
+}
+
+// describes the values of a type as they are written in a staskfile
+func describeType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return "a number"
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String {
+			return "a list of strings"
+		}
+		return "a list"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return t.String()
+}
+
+// returns the position in the source of the rune at offset in a string, false when it is not known
+func (node *docNode) runePosition(offset int) (int, int, bool) {
+	if offset < 0 || offset >= len(node.Runes) {
+		return node.Line, node.Column, false
+	}
+	return node.Runes[offset].Line, node.Runes[offset].Column, true
+}
+
+// decodes the node into v like json.Unmarshal would, so it goes through the same checks as the whole staskfile
+func (node *docNode) decode(v interface{}) error {
+	data, err := json.Marshal(node.value())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// reads a staskfile along with the positions of its values
+func parseDocument(data []byte, format string) (*docNode, error) {
+	switch format {
+	case FormatYAML:
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		if len(doc.Content) == 0 {
+			return &docNode{Kind: nodeObject}, nil
+		}
+		return yamlDocNode(doc.Content[0], strings.Split(string(data), "\n"))
+	case FormatTOML:
+		var doc map[string]interface{}
+		if _, err := toml.Decode(string(data), &doc); err != nil {
+			return nil, err
+		}
+		node := valueDocNode(doc)
+		// only the positions of the tasks can be found again, see findTOMLKey
+		for _, member := range node.Members {
+			if member.Key != "Tasks" || member.Value.Kind != nodeObject {
+				continue
+			}
+			for i, task := range member.Value.Members {
+				member.Value.Members[i].Line, member.Value.Members[i].Column = findTOMLKey(string(data), task.Key)
+			}
+		}
+		return node, nil
+	}
+
+	data = stripJSONComments(data)
+	node, _, err := jsonDocNode(data, skipJSONSpace(data, 0))
+	return node, err
+}
+
+func jsonDocNode(data []byte, start int) (*docNode, int, error) {
+	line, column := lineAndColumn(data, start)
+	node := &docNode{Line: line, Column: column}
+	if start >= len(data) {
+		return nil, start, errJSONDocument
+	}
+
+	switch data[start] {
+	case '{':
+		object, err := readJSONObject(data, start)
+		if err != nil {
+			return nil, start, err
+		}
+		node.Kind = nodeObject
+		for _, member := range object.members {
+			value, _, err := jsonDocNode(data, member.valueStart)
+			if err != nil {
+				return nil, start, err
+			}
+			var key string
+			if err := json.Unmarshal(data[member.start:member.start+len(member.key)+2], &key); err != nil {
+				return nil, start, err
+			}
+			line, column := lineAndColumn(data, member.start)
+			node.Members = append(node.Members, docMember{key, line, column, value})
+		}
+		return node, object.close + 1, nil
+
+	case '[':
+		node.Kind = nodeArray
+		i := skipJSONSpace(data, start+1)
+		for i < len(data) && data[i] != ']' {
+			item, end, err := jsonDocNode(data, i)
+			if err != nil {
+				return nil, start, err
+			}
+			node.Items = append(node.Items, item)
+			i = skipJSONSpace(data, end)
+			if i < len(data) && data[i] == ',' {
+				i = skipJSONSpace(data, i+1)
+			}
+		}
+		return node, i + 1, nil
+	}
+
+	end, err := skipJSONValue(data, start)
+	if err != nil {
+		return nil, start, err
+	}
+	if err := json.Unmarshal(data[start:end], &node.Value); err != nil {
+		return nil, start, err
+	}
+	if str, ok := node.Value.(string); ok {
+		// strings cannot span lines in JSON, each rune is on the line of the string
+		node.Runes = quotedRunePositions(string(data[start+1:end]), '"', line, column+1, jsonEscapeLength, str)
+	}
+	return node, end, nil
+}
+
+// returns the positions of the runes of str, written between quotes from line and column
+// escape returns the length of the escape sequence the source starts with, 0 when it does not start with one
+// nil is returned when the source does not have as many runes as str
+func quotedRunePositions(source string, quote byte, line int, column int, escape func(string) int, str string) []docPosition {
+	var positions []docPosition
+	for i := 0; i < len(source); {
+		length := escape(source[i:])
+		if length == 0 {
+			if source[i] == quote {
+				break
+			}
+			_, length = utf8.DecodeRuneInString(source[i:])
+		}
+		if i+length > len(source) {
+			return nil
+		}
+		positions = append(positions, docPosition{line, column})
+		column += utf8.RuneCountInString(source[i : i+length])
+		i += length
+	}
+	if len(positions) != utf8.RuneCountInString(str) {
+		return nil
+	}
+	return positions
+}
+
+func jsonEscapeLength(source string) int {
+	if !strings.HasPrefix(source, "\\") || len(source) < 2 {
+		return 0
+	}
+	if source[1] != 'u' {
+		return 2
+	}
+	// a surrogate pair decodes to a single rune
+	if strings.HasPrefix(source, "\\ud") || strings.HasPrefix(source, "\\uD") {
+		if len(source) >= 12 && strings.ContainsAny(source[3:4], "89abAB") && source[6:8] == "\\u" {
+			return 12
+		}
+	}
+	return 6
+}
+
+func yamlDoubleQuotedEscapeLength(source string) int {
+	if !strings.HasPrefix(source, "\\") || len(source) < 2 {
+		return 0
+	}
+	switch source[1] {
+	case 'x':
+		return 4
+	case 'u':
+		return 6
+	case 'U':
+		return 10
+	}
+	return 2
+}
+
+func yamlSingleQuotedEscapeLength(source string) int {
+	if strings.HasPrefix(source, "''") {
+		return 2
+	}
+	return 0
+}
+
+// returns the positions of the runes of a YAML string written on the line of its node, or as a literal block
+// nil is returned for other styles, and when the source does not read as str
+func yamlRunePositions(node *yaml.Node, lines []string, str string) []docPosition {
+	if node.Line < 1 || node.Line > len(lines) {
+		return nil
+	}
+	text := []rune(lines[node.Line-1])
+	if node.Column < 1 || node.Column > len(text) {
+		return nil
+	}
+	source := string(text[node.Column-1:])
+
+	switch node.Style {
+	case 0:
+		if strings.ContainsRune(str, '\n') || !strings.HasPrefix(source, str) {
+			return nil
+		}
+		positions := make([]docPosition, utf8.RuneCountInString(str))
+		for i := range positions {
+			positions[i] = docPosition{node.Line, node.Column + i}
+		}
+		return positions
+	case yaml.DoubleQuotedStyle:
+		return quotedRunePositions(source[1:], '"', node.Line, node.Column+1, yamlDoubleQuotedEscapeLength, str)
+	case yaml.SingleQuotedStyle:
+		return quotedRunePositions(source[1:], '\'', node.Line, node.Column+1, yamlSingleQuotedEscapeLength, str)
+	case yaml.LiteralStyle:
+		// each line of the string is a line of the block, after its indentation
+		indent := -1
+		for _, line := range lines[node.Line:] {
+			if len(strings.TrimSpace(line)) > 0 {
+				indent = len(line) - len(strings.TrimLeft(line, " "))
+				break
+			}
+		}
+		var positions []docPosition
+		for i, part := range strings.SplitAfter(str, "\n") {
+			line := node.Line + 1 + i
+			content := strings.TrimSuffix(part, "\n")
+			if len(content) > 0 && (line > len(lines) || len(lines[line-1]) < indent || lines[line-1][indent:] != content) {
+				return nil
+			}
+			for j := range []rune(part) {
+				positions = append(positions, docPosition{line, indent + 1 + j})
+			}
+		}
+		return positions
+	}
+	return nil
+}
+
+// returns the line and the character of offset in data, counting from 1
+func lineAndColumn(data []byte, offset int) (int, int) {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	before := data[:offset]
+	line := strings.Count(string(before), "\n") + 1
+	lineStart := strings.LastIndex(string(before), "\n") + 1
+	return line, utf8.RuneCount(before[lineStart:]) + 1
+}
+
+func yamlDocNode(node *yaml.Node, lines []string) (*docNode, error) {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	doc := &docNode{Line: node.Line, Column: node.Column}
+	switch node.Kind {
+	case yaml.MappingNode:
+		doc.Kind = nodeObject
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			value, err := yamlDocNode(node.Content[i+1], lines)
+			if err != nil {
+				return nil, err
+			}
+			doc.Members = append(doc.Members, docMember{key.Value, key.Line, key.Column, value})
+		}
+	case yaml.SequenceNode:
+		doc.Kind = nodeArray
+		for _, item := range node.Content {
+			value, err := yamlDocNode(item, lines)
+			if err != nil {
+				return nil, err
+			}
+			doc.Items = append(doc.Items, value)
+		}
+	default:
+		if err := node.Decode(&doc.Value); err != nil {
+			return nil, err
+		}
+		if str, ok := doc.Value.(string); ok {
+			doc.Runes = yamlRunePositions(node, lines, str)
+		}
+	}
+	return doc, nil
+}
+
+// returns a node without positions, for formats that do not keep them
+func valueDocNode(value interface{}) *docNode {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		node := &docNode{Kind: nodeObject}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			node.Members = append(node.Members, docMember{Key: key, Value: valueDocNode(v[key])})
+		}
+		return node
+	case []map[string]interface{}:
+		node := &docNode{Kind: nodeArray}
+		for _, item := range v {
+			node.Items = append(node.Items, valueDocNode(item))
+		}
+		return node
+	case []interface{}:
+		node := &docNode{Kind: nodeArray}
+		for _, item := range v {
+			node.Items = append(node.Items, valueDocNode(item))
+		}
+		return node
+	}
+	return &docNode{Value: value}
+}
@@ -0,0 +1,77 @@
+package staskfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// names of project staskfiles without their extension, in order of preference when a directory has several
+var ProjectFileBaseNames = []string{".stask", "staskfile"}
+
+// names of project staskfiles, in order of preference when a directory has several
+var ProjectFileNames = fileNames(ProjectFileBaseNames)
+
+// returns every base name with every staskfile extension, see Extensions
+func fileNames(baseNames []string) []string {
+	var names []string
+	for _, base := range baseNames {
+		for _, ext := range Extensions {
+			names = append(names, base+ext)
+		}
+	}
+	return names
+}
+
+// looks for a project staskfile in dir and then in each of its parents, like git does for .git
+// the search stops at the home directory, and skips the global staskfile at globalPath
+// returns the path of the first one found
+func FindProjectStaskfile(dir string, globalPath string) (string, bool) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	// without a home directory the search goes up to the root
+	home, _ := os.UserHomeDir()
+	if len(home) > 0 {
+		home, _ = filepath.Abs(home)
+	}
+	if len(globalPath) > 0 {
+		globalPath, _ = filepath.Abs(globalPath)
+	}
+
+	for {
+		for _, name := range ProjectFileNames {
+			path := filepath.Join(dir, name)
+			if path != globalPath && isFile(path) {
+				return path, true
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir || dir == home {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// looks for a staskfile named baseName in dir, with any of the staskfile extensions
+// returns the path of the first one found
+func FindStaskfile(dir string, baseName string) (string, bool) {
+	return findInDir(dir, fileNames([]string{baseName}))
+}
+
+func findInDir(dir string, names []string) (string, bool) {
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if isFile(path) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
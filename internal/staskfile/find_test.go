@@ -0,0 +1,66 @@
+package staskfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itsfrank/stask/internal/staskfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindProjectStaskfile(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(root, "repo")
+	sub := filepath.Join(repo, "src", "sub")
+	other := filepath.Join(root, "other", "sub")
+	assert.Nil(t, os.MkdirAll(sub, 0755))
+	assert.Nil(t, os.MkdirAll(other, 0755))
+	assert.Nil(t, os.MkdirAll(filepath.Join(other, ".stask.json"), 0755))
+
+	assert.Nil(t, os.WriteFile(filepath.Join(repo, "staskfile.json"), []byte("{}"), 0644))
+	path, found := staskfile.FindProjectStaskfile(sub, "")
+	assert.True(t, found)
+	assert.Equal(t, filepath.Join(repo, "staskfile.json"), path)
+
+	assert.Nil(t, os.WriteFile(filepath.Join(repo, ".stask.json"), []byte("{}"), 0644))
+	path, found = staskfile.FindProjectStaskfile(sub, "")
+	assert.True(t, found)
+	assert.Equal(t, filepath.Join(repo, ".stask.json"), path)
+
+	assert.Nil(t, os.WriteFile(filepath.Join(sub, "staskfile.json"), []byte("{}"), 0644))
+	path, found = staskfile.FindProjectStaskfile(sub, "")
+	assert.True(t, found)
+	assert.Equal(t, filepath.Join(sub, "staskfile.json"), path)
+
+	// directories named like a staskfile are skipped
+	_, found = staskfile.FindProjectStaskfile(other, "")
+	assert.False(t, found)
+}
+
+func TestFindProjectStaskfileHome(t *testing.T) {
+	root := t.TempDir()
+	home := filepath.Join(root, "home")
+	globalDir := filepath.Join(home, ".config", "stask")
+	sub := filepath.Join(globalDir, "sub")
+	repo := filepath.Join(home, "repo")
+	assert.Nil(t, os.MkdirAll(sub, 0755))
+	assert.Nil(t, os.MkdirAll(repo, 0755))
+	t.Setenv("HOME", home)
+
+	// the global staskfile is not a project staskfile
+	globalPath := filepath.Join(globalDir, "staskfile.json")
+	assert.Nil(t, os.WriteFile(globalPath, []byte("{}"), 0644))
+	_, found := staskfile.FindProjectStaskfile(sub, globalPath)
+	assert.False(t, found)
+
+	// the search stops at the home directory
+	assert.Nil(t, os.WriteFile(filepath.Join(root, ".stask.json"), []byte("{}"), 0644))
+	_, found = staskfile.FindProjectStaskfile(repo, globalPath)
+	assert.False(t, found)
+
+	assert.Nil(t, os.WriteFile(filepath.Join(home, ".stask.json"), []byte("{}"), 0644))
+	path, found := staskfile.FindProjectStaskfile(repo, globalPath)
+	assert.True(t, found)
+	assert.Equal(t, filepath.Join(home, ".stask.json"), path)
+}
@@ -0,0 +1,373 @@
+package staskfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/itsfrank/stask/internal/jsonerror"
+	"gopkg.in/yaml.v3"
+)
+
+// formats a staskfile can be written in, chosen by the extension of its path
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+var Formats = []string{FormatJSON, FormatYAML, FormatTOML}
+
+// extensions of staskfiles, in order of preference when a directory has several
+var Extensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+// returns the format of the staskfile at path, JSON unless its extension says otherwise
+func FormatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	}
+	return FormatJSON
+}
+
+// returns the extension of staskfiles written in format
+func Extension(format string) string {
+	if format == FormatJSON {
+		return ".json"
+	}
+	return "." + format
+}
+
+// parses a staskfile written in format, older staskfiles are upgraded to CurrentVersion
+// staskfiles written for a newer stask are refused with a VersionError
+func ParseStaskfileFormat(data []byte, format string) (Staskfile, error) {
+	sf, _, err := parseVersioned(data, format)
+	return sf, err
+}
+
+func parseFormat(data []byte, format string) (Staskfile, error) {
+	switch format {
+	case FormatYAML:
+		return parseYAML(data)
+	case FormatTOML:
+		return parseTOML(data)
+	}
+	return parseJSON(data)
+}
+
+func SerializeStaskfileFormat(staskfile Staskfile, format string) ([]byte, error) {
+	staskfile.Version = CurrentVersion
+	switch format {
+	case FormatYAML:
+		return serializeYAML(staskfile)
+	case FormatTOML:
+		return serializeTOML(staskfile)
+	}
+	return SerializeStaskfile(staskfile)
+}
+
+func parseYAML(data []byte) (Staskfile, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return Empty(), getFormattedYAMLError(data, err)
+	}
+	var staskfile Staskfile
+	if len(doc.Content) == 0 {
+		return initialized(staskfile), nil
+	}
+	foldYAMLKeys(doc.Content[0], fieldNames)
+	if err := doc.Decode(&staskfile); err != nil {
+		return Empty(), getFormattedYAMLTypeError(&doc, err)
+	}
+	return initialized(staskfile), nil
+}
+
+// encoding/json matches fields without case, keys of a YAML mapping written in another case are renamed
+// to the name of their field so both formats read the same staskfiles
+func foldYAMLKeys(node *yaml.Node, names []string) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	keys := map[string]bool{}
+	for i := 0; i < len(node.Content); i += 2 {
+		keys[node.Content[i].Value] = true
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		key := node.Content[i]
+		for _, name := range names {
+			if key.Value != name && strings.EqualFold(key.Value, name) && !keys[name] {
+				key.Value = name
+				keys[name] = true
+			}
+		}
+	}
+}
+
+func serializeYAML(staskfile Staskfile) ([]byte, error) {
+	var builder strings.Builder
+	encoder := yaml.NewEncoder(&builder)
+	encoder.SetIndent(4)
+	if err := encoder.Encode(staskfile); err != nil {
+		return nil, err
+	}
+	return []byte(builder.String()), nil
+}
+
+// errors in tasks written as YAML nodes, with the position of the node
+func yamlNodeError(node *yaml.Node, err error) error {
+	return &jsonerror.PositionError{
+		Line:      node.Line,
+		Character: node.Column,
+		Message:   fmt.Sprintf("line %d, character %d: %v", node.Line, node.Column, err),
+		Err:       err,
+	}
+}
+
+// formats yaml.v3 syntax errors like jsonerror.GetFormattedError does for JSON
+func getFormattedYAMLError(input []byte, err error) error {
+	message, found := strings.CutPrefix(err.Error(), "yaml: ")
+	if !found {
+		return err
+	}
+	// yaml.v3 only gives the line, in front of the message, the character is the first one of the line
+	// that is not a space as that is where the token that could not be read starts most of the time
+	var line int
+	if _, err := fmt.Sscanf(message, "line %d:", &line); err != nil {
+		return &jsonerror.PositionError{Message: "syntax error - " + message}
+	}
+	character := 1
+	if lines := strings.Split(string(input), "\n"); line <= len(lines) {
+		character = len(lines[line-1]) - len(strings.TrimLeft(lines[line-1], " ")) + 1
+	}
+	message = fmt.Sprintf("line %d, character %d:%s", line, character, strings.TrimPrefix(message, fmt.Sprintf("line %d:", line)))
+	return &jsonerror.PositionError{Line: line, Character: character, Message: "syntax error - " + message}
+}
+
+// formats yaml.v3 type errors like jsonerror.GetFormattedError does for JSON, the errors only give the line of
+// the value so its character is found in the document
+func getFormattedYAMLTypeError(doc *yaml.Node, err error) error {
+	var typeError *yaml.TypeError
+	if !errors.As(err, &typeError) {
+		return err
+	}
+	var position *jsonerror.PositionError
+	messages := make([]string, len(typeError.Errors))
+	for i, message := range typeError.Errors {
+		messages[i] = message
+		var line int
+		if _, err := fmt.Sscanf(message, "line %d:", &line); err != nil {
+			continue
+		}
+		character := yamlValueColumn(doc, line)
+		if character == 0 {
+			character = 1
+		}
+		messages[i] = fmt.Sprintf("line %d, character %d:%s", line, character, strings.TrimPrefix(message, fmt.Sprintf("line %d:", line)))
+		if position == nil {
+			position = &jsonerror.PositionError{Line: line, Character: character}
+		}
+	}
+	message := "yaml type cannot be converted - " + strings.Join(messages, ", ")
+	if position == nil {
+		return errors.New(message)
+	}
+	position.Message = message
+	return position
+}
+
+// returns the column of the first value on line, or 0 if there is none
+// block mappings and sequences start where their first item does, so the value is looked for in their items first
+func yamlValueColumn(node *yaml.Node, line int) int {
+	if node.Style&yaml.FlowStyle == 0 {
+		for i, child := range node.Content {
+			// keys are never converted, only their values
+			if node.Kind == yaml.MappingNode && i%2 == 0 {
+				continue
+			}
+			if column := yamlValueColumn(child, line); column > 0 {
+				return column
+			}
+		}
+	}
+	if node.Kind != yaml.DocumentNode && node.Line == line {
+		return node.Column
+	}
+	return 0
+}
+
+// TOML documents are converted to JSON, so their tasks are decoded the same way
+func parseTOML(data []byte) (Staskfile, error) {
+	var doc map[string]interface{}
+	_, err := toml.Decode(string(data), &doc)
+	if err != nil {
+		return Empty(), getFormattedTOMLError(string(data), err)
+	}
+
+	converted, err := json.Marshal(doc)
+	if err != nil {
+		return Empty(), err
+	}
+	var staskfile Staskfile
+	err = json.Unmarshal(converted, &staskfile)
+	if err != nil {
+		return Empty(), findTOMLTaskError(string(data), doc, err)
+	}
+	return initialized(staskfile), nil
+}
+
+// TOML does not support mixing values and tables freely, the staskfile goes through JSON to get plain values
+func serializeTOML(staskfile Staskfile) ([]byte, error) {
+	converted, err := json.Marshal(staskfile)
+	if err != nil {
+		return nil, err
+	}
+	// numbers are kept as written, a plain interface{} would make Version = 1 a float
+	decoder := json.NewDecoder(bytes.NewReader(converted))
+	decoder.UseNumber()
+	var doc map[string]interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var builder strings.Builder
+	encoder := toml.NewEncoder(&builder)
+	encoder.Indent = ""
+	if err := encoder.Encode(tomlNumbers(doc)); err != nil {
+		return nil, err
+	}
+	return []byte(builder.String()), nil
+}
+
+// converts the json.Number values of a decoded document to integers, or floats when they are not whole
+func tomlNumbers(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, member := range value {
+			value[key] = tomlNumbers(member)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = tomlNumbers(item)
+		}
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		if f, err := value.Float64(); err == nil {
+			return f
+		}
+	}
+	return value
+}
+
+// formats toml errors like jsonerror.GetFormattedError does for JSON
+func getFormattedTOMLError(input string, err error) error {
+	var parseError toml.ParseError
+	if !errors.As(err, &parseError) {
+		return err
+	}
+
+	// the position is a byte offset, characters are counted from the start of its line
+	start := parseError.Position.Start
+	if start > len(input) {
+		start = len(input)
+	}
+	lineStart := strings.LastIndex(input[:start], "\n") + 1
+	character := len([]rune(input[lineStart:start])) + 1
+
+	// the message is only available with the position in front of it
+	prefix := fmt.Sprintf("toml: line %d: ", parseError.Position.Line)
+	if len(parseError.LastKey) > 0 {
+		prefix = fmt.Sprintf("toml: line %d (last key %q): ", parseError.Position.Line, parseError.LastKey)
+	}
+	message := strings.TrimPrefix(parseError.Error(), prefix)
+	return &jsonerror.PositionError{
+		Line:      parseError.Position.Line,
+		Character: character,
+		Message:   fmt.Sprintf("syntax error - line %d, character %d: %s", parseError.Position.Line, character, message),
+	}
+}
+
+// decoded TOML has no positions left, the task that failed is decoded again on its own to name it
+// along with the line it is defined on
+func findTOMLTaskError(input string, doc map[string]interface{}, err error) error {
+	tasks, ok := doc["Tasks"].(map[string]interface{})
+	if !ok {
+		return err
+	}
+
+	names := make([]string, 0, len(tasks))
+	for name := range tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data, _ := json.Marshal(tasks[name])
+		var task Task
+		if taskErr := json.Unmarshal(data, &task); taskErr != nil {
+			if line, character := findTOMLKey(input, name); line > 0 {
+				return &jsonerror.PositionError{
+					Line:      line,
+					Character: character,
+					Message:   fmt.Sprintf("task '%s' - line %d, character %d: %v", name, line, character, taskErr),
+					Err:       taskErr,
+				}
+			}
+			return fmt.Errorf("task '%s': %w", name, taskErr)
+		}
+	}
+	return err
+}
+
+// encoding/json gives the offset of errors in the tasks from the start of the task, and none for the errors
+// of Task.UnmarshalJSON, the task that failed is decoded again on its own to name it along with its position
+// returns nil when no task fails on its own
+func findJSONTaskError(data []byte) error {
+	doc, err := parseDocument(data, FormatJSON)
+	if err != nil || doc.Kind != nodeObject {
+		return nil
+	}
+	tasks := doc.member("Tasks")
+	if tasks == nil || tasks.Kind != nodeObject {
+		return nil
+	}
+	for _, member := range tasks.Members {
+		_, node, taskErr := decodeTask(member.Value)
+		if taskErr == nil {
+			continue
+		}
+		return &jsonerror.PositionError{
+			Line:      node.Line,
+			Character: node.Column,
+			Message:   fmt.Sprintf("task '%s' - line %d, character %d: %v", member.Key, node.Line, node.Column, taskErr),
+			Err:       taskErr,
+		}
+	}
+	return nil
+}
+
+// returns the line and character of the key a task is defined with, as a key or in a table header,
+// or 0 if it is not found
+func findTOMLKey(input string, name string) (int, int) {
+	keys := []string{name, `"` + name + `"`, `'` + name + `'`}
+	for i, line := range strings.Split(input, "\n") {
+		trimmed := strings.TrimSpace(line)
+		character := len([]rune(line[:strings.Index(line, trimmed)])) + 1
+		for _, key := range keys {
+			if trimmed == "[Tasks."+key+"]" {
+				return i + 1, character + len("[Tasks.")
+			}
+			if rest, found := strings.CutPrefix(trimmed, key); found && strings.HasPrefix(strings.TrimSpace(rest), "=") {
+				return i + 1, character
+			}
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,208 @@
+package staskfile_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itsfrank/stask/internal/staskfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatOf(t *testing.T) {
+	assert.Equal(t, staskfile.FormatJSON, staskfile.FormatOf("staskfile.json"))
+	assert.Equal(t, staskfile.FormatYAML, staskfile.FormatOf(".stask.yaml"))
+	assert.Equal(t, staskfile.FormatYAML, staskfile.FormatOf("dir/staskfile.YML"))
+	assert.Equal(t, staskfile.FormatTOML, staskfile.FormatOf("staskfile.toml"))
+	assert.Equal(t, staskfile.FormatJSON, staskfile.FormatOf("staskfile"))
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	sf := staskfile.Staskfile{
+		Version: staskfile.CurrentVersion,
+		Include: []staskfile.Include{{Path: "./common.json"}, {Path: "./tools.yaml", As: "tools"}},
+		Tasks: map[string]staskfile.Task{
+			"hello":   {Cmd: "echo hello"},
+			"rebuild": {Steps: []staskfile.Step{{Cmd: "make clean", ContinueOnError: true}, {Cmd: "make"}}},
+			"list":    {Steps: []staskfile.Step{{Cmd: "a"}, {Cmd: "b"}}},
+			"build":   {Cmd: "make {target}", Desc: "build it", Cwd: "{repo}", Env: map[string]string{"CC": "{cc}"}, Deps: []string{"hello"}},
+			"dev":     {Parallel: []string{"hello", "build"}, FailFast: true, Exec: staskfile.ExecDirect},
+		},
+		State:    map[string]string{"repo": "~/src/repo", "cc": "clang"},
+		Profiles: map[string]map[string]string{"gcc": {"cc": "gcc"}},
+	}
+
+	for _, format := range staskfile.Formats {
+		t.Run(format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "staskfile"+staskfile.Extension(format))
+			assert.Nil(t, staskfile.WriteStaskfile(path, sf))
+			read, err := staskfile.ReadStaskfileNoIncludes(path)
+			assert.Nil(t, err)
+			assert.Equal(t, sf, read)
+		})
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	data := `
+# comments are allowed
+Tasks:
+    hello: echo hello
+    build: |
+        cmake -B build
+        cmake --build build
+    rebuild:
+        - make clean
+        - cmd: make
+          continue_on_error: true
+    test:
+        cmd: ctest
+        deps: [build]
+State:
+    flavor: debug
+`
+	sf, err := staskfile.ParseStaskfileFormat([]byte(data), staskfile.FormatYAML)
+	assert.Nil(t, err)
+	assert.Equal(t, staskfile.Task{Cmd: "echo hello"}, sf.Tasks["hello"])
+	assert.Equal(t, staskfile.Task{Cmd: "cmake -B build\ncmake --build build\n"}, sf.Tasks["build"])
+	assert.Equal(t, staskfile.Task{Steps: []staskfile.Step{{Cmd: "make clean"}, {Cmd: "make", ContinueOnError: true}}}, sf.Tasks["rebuild"])
+	assert.Equal(t, staskfile.Task{Cmd: "ctest", Deps: []string{"build"}}, sf.Tasks["test"])
+	assert.Equal(t, map[string]string{"flavor": "debug"}, sf.State)
+	assert.NotNil(t, sf.Profiles)
+}
+
+func TestParseYAMLFieldCase(t *testing.T) {
+	// fields are matched without case, as encoding/json does for JSON staskfiles
+	data := `
+Version: 1
+tasks:
+    hello:
+        CMD: echo hello
+        Deps: [build]
+    build: make
+state:
+    flavor: debug
+`
+	sf, err := staskfile.ParseStaskfileFormat([]byte(data), staskfile.FormatYAML)
+	assert.Nil(t, err)
+	assert.Equal(t, staskfile.Task{Cmd: "echo hello", Deps: []string{"build"}}, sf.Tasks["hello"])
+	assert.Equal(t, staskfile.Task{Cmd: "make"}, sf.Tasks["build"])
+	assert.Equal(t, map[string]string{"flavor": "debug"}, sf.State)
+}
+
+func TestParseTOML(t *testing.T) {
+	data := `
+# comments are allowed
+[Tasks]
+hello = "echo hello"
+rebuild = ["make clean", { cmd = "make", continue_on_error = true }]
+
+[Tasks.test]
+cmd = "ctest"
+deps = ["build"]
+
+[State]
+flavor = "debug"
+`
+	sf, err := staskfile.ParseStaskfileFormat([]byte(data), staskfile.FormatTOML)
+	assert.Nil(t, err)
+	assert.Equal(t, staskfile.Task{Cmd: "echo hello"}, sf.Tasks["hello"])
+	assert.Equal(t, staskfile.Task{Steps: []staskfile.Step{{Cmd: "make clean"}, {Cmd: "make", ContinueOnError: true}}}, sf.Tasks["rebuild"])
+	assert.Equal(t, staskfile.Task{Cmd: "ctest", Deps: []string{"build"}}, sf.Tasks["test"])
+	assert.Equal(t, map[string]string{"flavor": "debug"}, sf.State)
+	assert.NotNil(t, sf.Profiles)
+}
+
+func TestSerializeTOML(t *testing.T) {
+	sf := staskfile.Empty()
+	sf.Version = staskfile.CurrentVersion
+	sf.Tasks["build"] = staskfile.Task{Cmd: "make"}
+
+	data, err := staskfile.SerializeStaskfileFormat(sf, staskfile.FormatTOML)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), fmt.Sprintf("Version = %d\n", staskfile.CurrentVersion))
+	assert.NotContains(t, string(data), ".0")
+
+	parsed, err := staskfile.ParseStaskfileFormat(data, staskfile.FormatTOML)
+	assert.Nil(t, err)
+	assert.Equal(t, sf.Version, parsed.Version)
+	assert.Equal(t, sf.Tasks, parsed.Tasks)
+}
+
+func TestFormatErrors(t *testing.T) {
+	var tests = []struct {
+		name   string
+		format string
+		data   string
+		err    string
+	}{
+		{"YAMLSyntax", staskfile.FormatYAML, "Tasks:\n\thello: echo\n", "syntax error - line 2, character 1: found character that cannot start any token"},
+		{"YAMLTaskType", staskfile.FormatYAML, "Tasks:\n    hello:\n        cmd: a\n        steps: [b]\n", "line 3, character 9: a task cannot have both \"cmd\" and \"steps\""},
+		{"YAMLValueType", staskfile.FormatYAML, "State: [a]\n", "yaml type cannot be converted - line 1, character 8: cannot unmarshal"},
+		{"YAMLNestedValueType", staskfile.FormatYAML, "Tasks:\n    hello:\n        env: [a]\n", "yaml type cannot be converted - line 3, character 14: cannot unmarshal"},
+		{"TOMLSyntax", staskfile.FormatTOML, "[Tasks]\nhello = \"echo\n", "syntax error - line 2, character"},
+		{"JSONTaskEnv", staskfile.FormatJSON, jsonTaskError(`{"cmd": "x", "env": {"A": 1}}`), "task 'b' - line 4, character 36: env 'A' must be a string"},
+		{"JSONTaskDeps", staskfile.FormatJSON, jsonTaskError(`{"deps": "y"}`), "task 'b' - line 4, character 19: deps must be a list of strings"},
+		{"JSONTaskDep", staskfile.FormatJSON, jsonTaskError(`{"deps": ["a", 2]}`), "task 'b' - line 4, character 25: deps item 2 must be a string"},
+		{"JSONTaskType", staskfile.FormatJSON, jsonTaskError(`5`), "task 'b' - line 4, character 10: a task must be a command string"},
+		{"JSONTaskExec", staskfile.FormatJSON, jsonTaskError(`{"cmd": "x", "exec": "bogus"}`), "task 'b' - line 4, character 10: unknown exec mode 'bogus'"},
+		{"TOMLTaskType", staskfile.FormatTOML, "[Tasks]\nhello = \"echo\"\n\n[Tasks.build]\ncmd = \"a\"\nsteps = [\"b\"]\n", "task 'build' - line 4, character 8: a task cannot have both \"cmd\" and \"steps\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := staskfile.ParseStaskfileFormat([]byte(tt.data), tt.format)
+			if assert.NotNil(t, err) {
+				assert.Contains(t, err.Error(), tt.err)
+			}
+		})
+	}
+}
+
+// a staskfile whose task 'b' on line 4 is written as task
+func jsonTaskError(task string) string {
+	return "{\n  // tasks\n  \"Tasks\": {\"a\": \"x\",\n    \"b\": " + task + "\n  }\n}\n"
+}
+
+func TestReadStaskfileParseError(t *testing.T) {
+	var tests = []struct {
+		name   string
+		file   string
+		data   string
+		line   int
+		column int
+	}{
+		{"JSONSyntax", "staskfile.json", "{\n    \"Tasks\": {\"a\" \"b\"}\n}", 2, 21},
+		{"JSONType", "staskfile.json", "{\n    \"State\": []\n}", 2, 16},
+		{"JSONTask", "staskfile.json", jsonTaskError(`{"cmd": "x", "env": {"A": 1}}`), 4, 36},
+		{"YAMLSyntax", "staskfile.yaml", "Tasks:\n\thello: echo\n", 2, 1},
+		{"YAMLType", "staskfile.yaml", "Version: 1\nState:\n    dir: [a]\n", 3, 10},
+		{"YAMLTaskType", "staskfile.yaml", "Tasks:\n    hello:\n        cmd: a\n        steps: [b]\n", 3, 9},
+		{"TOMLSyntax", "staskfile.toml", "[Tasks]\nhello = \"echo\n", 2, 14},
+		{"TOMLTaskType", "staskfile.toml", "[Tasks.build]\ncmd = \"a\"\nsteps = [\"b\"]\n", 1, 8},
+		{"TOMLTaskKey", "staskfile.toml", "[Tasks]\n  build = {cmd = \"a\", steps = [\"b\"]}\n", 2, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			assert.Nil(t, os.WriteFile(path, []byte(tt.data), 0644))
+
+			_, err := staskfile.ReadStaskfile(path)
+			var parseErr *staskfile.ParseError
+			if assert.True(t, errors.As(err, &parseErr)) {
+				assert.Equal(t, path, parseErr.Path)
+				assert.Equal(t, tt.line, parseErr.Line)
+				assert.Equal(t, tt.column, parseErr.Column)
+			}
+		})
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	dir := t.TempDir()
+	assert.Equal(t, filepath.Join(dir, ".stask.local.yaml"), staskfile.LocalPath(filepath.Join(dir, ".stask.yaml")))
+
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, ".stask.local.toml"), []byte(""), 0644))
+	assert.Equal(t, filepath.Join(dir, ".stask.local.toml"), staskfile.LocalPath(filepath.Join(dir, ".stask.yaml")))
+}
@@ -0,0 +1,210 @@
+package staskfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// separates the namespace of an included staskfile from the names of its tasks, e.g. common:build
+const NamespaceSeparator = ":"
+
+// a staskfile included by another one, written as a path or as an object to choose its namespace:
+//
+//	"Include": ["~/team/stask/common.json", {"path": "./tools/stask.json", "as": "tools"}]
+//
+// relative paths are relative to the directory of the including staskfile
+type Include struct {
+	Path string `json:"path" yaml:"path"`
+	// prefix of the included task names, the file name without its extension by default
+	As string `json:"as,omitempty" yaml:"as,omitempty"`
+}
+
+// the prefix of the included task names
+func (include Include) Namespace() string {
+	if len(include.As) > 0 {
+		return include.As
+	}
+	name := filepath.Base(include.Path)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func (include *Include) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	*include = Include{}
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &include.Path)
+	}
+	if len(data) == 0 || data[0] != '{' {
+		return errIncludeType
+	}
+
+	type includeObject Include
+	var obj includeObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	*include = Include(obj)
+	return nil
+}
+
+func (include Include) MarshalJSON() ([]byte, error) {
+	if len(include.As) == 0 {
+		return json.Marshal(include.Path)
+	}
+	type includeObject Include
+	return json.Marshal(includeObject(include))
+}
+
+var errIncludeType = errors.New("an include must be a path string or an object")
+
+func (include *Include) UnmarshalYAML(node *yaml.Node) error {
+	*include = Include{}
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Decode(&include.Path)
+	case yaml.MappingNode:
+	default:
+		return yamlNodeError(node, errIncludeType)
+	}
+
+	type includeObject Include
+	var obj includeObject
+	foldYAMLKeys(node, jsonFieldNames(reflect.TypeOf(obj)))
+	if err := node.Decode(&obj); err != nil {
+		return err
+	}
+	*include = Include(obj)
+	return nil
+}
+
+func (include Include) MarshalYAML() (interface{}, error) {
+	if len(include.As) == 0 {
+		return include.Path, nil
+	}
+	type includeObject Include
+	return includeObject(include), nil
+}
+
+// returned when staskfiles include each other in a loop
+type IncludeCycleError struct {
+	// the chain of included paths, starting and ending with the same staskfile
+	Chain []string
+}
+
+func (err *IncludeCycleError) Error() string {
+	return "staskfiles include each other in a cycle: " + strings.Join(err.Chain, " -> ")
+}
+
+// reads the staskfile at path along with the staskfiles it includes, recursively
+// chain holds the absolute paths of the staskfiles including this one
+func readIncluding(path string, chain []string) (Staskfile, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return Empty(), err
+	}
+	for i, other := range chain {
+		if other == path {
+			cycle := append([]string{}, chain[i:]...)
+			return Empty(), &IncludeCycleError{append(cycle, path)}
+		}
+	}
+
+	sf, err := readStaskfileFile(path)
+	if err != nil {
+		return Empty(), err
+	}
+
+	chain = append(chain, path)
+	namespaces := map[string]bool{}
+	for _, include := range sf.Include {
+		if len(include.Path) == 0 {
+			return Empty(), fmt.Errorf("staskfile '%s' has an include without a path", path)
+		}
+		namespace := include.Namespace()
+		if namespaces[namespace] {
+			return Empty(), fmt.Errorf("staskfile '%s' includes several staskfiles in namespace '%s', use \"as\" to rename one", path, namespace)
+		}
+		namespaces[namespace] = true
+
+		includedPath, err := resolveIncludePath(filepath.Dir(path), include.Path)
+		if err != nil {
+			return Empty(), err
+		}
+		included, err := readIncluding(includedPath, chain)
+		if err != nil {
+			var cycle *IncludeCycleError
+			if errors.As(err, &cycle) {
+				return Empty(), err
+			}
+			return Empty(), fmt.Errorf("staskfile '%s', included by '%s': %w", include.Path, path, err)
+		}
+		sf.addIncluded(namespace, filepath.Dir(includedPath), included)
+	}
+	return sf, nil
+}
+
+// expands a leading "~/" to the home directory, relative paths are joined to dir
+func resolveIncludePath(dir string, path string) (string, error) {
+	if rest, found := strings.CutPrefix(path, "~/"); found {
+		homedir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(homedir, rest)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return path, nil
+}
+
+// adds the tasks of an included staskfile under its namespace, with the tasks they reference renamed to match
+// its state and profiles are added too, entries of the including staskfile take precedence
+// dir is the directory of the included staskfile, the cwd of its tasks is relative to it
+func (sf *Staskfile) addIncluded(namespace string, dir string, included Staskfile) {
+	rename := func(names []string) []string {
+		if names == nil {
+			return nil
+		}
+		renamed := make([]string, len(names))
+		for i, name := range names {
+			renamed[i] = name
+			if _, found := included.Tasks[name]; found {
+				renamed[i] = namespace + NamespaceSeparator + name
+			}
+		}
+		return renamed
+	}
+
+	for name, task := range included.Tasks {
+		name = namespace + NamespaceSeparator + name
+		if _, found := sf.Tasks[name]; found {
+			continue
+		}
+		task.Deps = rename(task.Deps)
+		task.Parallel = rename(task.Parallel)
+		// tasks included by the included staskfile keep the directory of their own staskfile
+		if len(task.Cwd) > 0 && len(task.Dir) == 0 {
+			task.Dir = dir
+		}
+		sf.Tasks[name] = task
+	}
+	for key, value := range included.State {
+		if _, found := sf.State[key]; !found {
+			sf.State[key] = value
+		}
+	}
+	for name, profile := range included.Profiles {
+		if _, found := sf.Profiles[name]; !found {
+			sf.Profiles[name] = profile
+		}
+	}
+}
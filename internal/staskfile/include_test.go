@@ -0,0 +1,137 @@
+package staskfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itsfrank/stask/internal/staskfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Nil(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestParseInclude(t *testing.T) {
+	var tests = []struct {
+		name     string
+		json     string
+		expected []staskfile.Include
+	}{
+		{"Path", `{"Include": ["common.json"]}`, []staskfile.Include{{Path: "common.json"}}},
+		{"Object", `{"Include": [{"path": "tools/stask.json", "as": "tools"}]}`, []staskfile.Include{{Path: "tools/stask.json", As: "tools"}}},
+		{"None", `{"Tasks": {}}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sf, err := staskfile.ParseStaskfile([]byte(tt.json))
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, sf.Include)
+		})
+	}
+}
+
+func TestParseIncludeError(t *testing.T) {
+	_, err := staskfile.ParseStaskfile([]byte(`{"Include": [3]}`))
+	assert.ErrorContains(t, err, "an include must be a path string or an object")
+}
+
+func TestIncludeNamespace(t *testing.T) {
+	assert.Equal(t, "common", staskfile.Include{Path: "~/team/stask/common.json"}.Namespace())
+	assert.Equal(t, "tools", staskfile.Include{Path: "./tools/stask.json", As: "tools"}.Namespace())
+}
+
+func TestIncludeRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "staskfile.json")
+	sf := staskfile.Empty()
+	sf.Include = []staskfile.Include{{Path: "common.json"}, {Path: "tools/stask.json", As: "tools"}}
+	assert.Nil(t, staskfile.WriteStaskfile(path, sf))
+
+	sfIn, err := staskfile.ReadStaskfileNoIncludes(path)
+	assert.Nil(t, err)
+	assert.Equal(t, sf, sfIn)
+}
+
+func TestReadStaskfileIncludes(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"repo/.stask.json": `{
+			"Include": ["../team/common.json", {"path": "tools/stask.json", "as": "tools"}],
+			"Tasks": {"ci": {"cmd": "make test", "deps": ["common:lint"]}, "common:fmt": "gofmt -l ."},
+			"State": {"flavor": "release"}
+		}`,
+		"team/common.json": `{
+			"Include": ["go.json"],
+			"Tasks": {"lint": {"cmd": "golangci-lint run", "deps": ["fmt", "go:vet", "setup"]}, "fmt": "go fmt ./..."},
+			"State": {"flavor": "debug", "linter": "golangci-lint"},
+			"Profiles": {"strict": {"linter": "staticcheck"}}
+		}`,
+		"team/go.json":          `{"Tasks": {"vet": {"cmd": "go vet ./...", "cwd": "src"}}}`,
+		"repo/tools/stask.json": `{"Tasks": {"all": {"parallel": ["codegen"]}, "codegen": {"cmd": "go generate ./...", "cwd": "gen"}}}`,
+	})
+
+	sf, err := staskfile.ReadStaskfile(filepath.Join(dir, "repo", ".stask.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]staskfile.Task{
+		"ci":            {Cmd: "make test", Deps: []string{"common:lint"}},
+		"common:fmt":    {Cmd: "gofmt -l ."},
+		"common:lint":   {Cmd: "golangci-lint run", Deps: []string{"common:fmt", "common:go:vet", "setup"}},
+		"common:go:vet": {Cmd: "go vet ./...", Cwd: "src", Dir: filepath.Join(dir, "team")},
+		"tools:all":     {Parallel: []string{"tools:codegen"}},
+		"tools:codegen": {Cmd: "go generate ./...", Cwd: "gen", Dir: filepath.Join(dir, "repo", "tools")},
+	}, sf.Tasks)
+	assert.Equal(t, map[string]string{"flavor": "release", "linter": "golangci-lint"}, sf.State)
+	assert.Equal(t, map[string]map[string]string{"strict": {"linter": "staticcheck"}}, sf.Profiles)
+}
+
+func TestReadStaskfileIncludeErrors(t *testing.T) {
+	var tests = []struct {
+		name     string
+		files    map[string]string
+		expected string
+	}{
+		{
+			"Cycle",
+			map[string]string{
+				"a.json": `{"Include": ["b.json"]}`,
+				"b.json": `{"Include": ["a.json"]}`,
+			},
+			"staskfiles include each other in a cycle: ",
+		},
+		{
+			"Missing",
+			map[string]string{"a.json": `{"Include": ["missing.json"]}`},
+			"staskfile 'missing.json', included by ",
+		},
+		{
+			"Syntax",
+			map[string]string{
+				"a.json": `{"Include": ["b.json"]}`,
+				"b.json": "{\n\"Tasks\": {\"x\" \"y\"}\n}",
+			},
+			"syntax error - line 2",
+		},
+		{
+			"SameNamespace",
+			map[string]string{
+				"a.json":     `{"Include": ["b.json", "sub/b.json"]}`,
+				"b.json":     `{}`,
+				"sub/b.json": `{}`,
+			},
+			"includes several staskfiles in namespace 'b'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			_, err := staskfile.ReadStaskfile(filepath.Join(dir, "a.json"))
+			assert.ErrorContains(t, err, tt.expected)
+		})
+	}
+}
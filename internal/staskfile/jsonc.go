@@ -0,0 +1,300 @@
+package staskfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// JSON staskfiles can have // and /* */ comments, like JSONC
+// they are replaced with spaces before parsing so errors keep their line and character
+func stripJSONComments(data []byte) []byte {
+	if !bytes.Contains(data, []byte("/")) {
+		return data
+	}
+
+	stripped := append([]byte{}, data...)
+	for i := 0; i < len(stripped); {
+		switch {
+		case stripped[i] == '"':
+			i = skipJSONString(stripped, i)
+		case stripped[i] == '/' && i+1 < len(stripped) && (stripped[i+1] == '/' || stripped[i+1] == '*'):
+			end := skipJSONComment(stripped, i)
+			for j := i; j < end; j++ {
+				if stripped[j] != '\n' && stripped[j] != '\r' {
+					stripped[j] = ' '
+				}
+			}
+			i = end
+		default:
+			i++
+		}
+	}
+	return stripped
+}
+
+// returns the offset after the string starting at i
+func skipJSONString(data []byte, i int) int {
+	for i += 1; i < len(data); i++ {
+		if data[i] == '\\' {
+			i++
+		} else if data[i] == '"' {
+			return i + 1
+		}
+	}
+	return len(data)
+}
+
+// returns the offset after the comment starting at i
+func skipJSONComment(data []byte, i int) int {
+	if data[i+1] == '/' {
+		end := bytes.IndexByte(data[i:], '\n')
+		if end < 0 {
+			return len(data)
+		}
+		return i + end
+	}
+	end := bytes.Index(data[i+2:], []byte("*/"))
+	if end < 0 {
+		return len(data)
+	}
+	return i + 2 + end + 2
+}
+
+// returns the offset of the first character at or after i that is not whitespace or a comment
+func skipJSONSpace(data []byte, i int) int {
+	for i < len(data) {
+		switch {
+		case data[i] == ' ' || data[i] == '\t' || data[i] == '\n' || data[i] == '\r':
+			i++
+		case data[i] == '/' && i+1 < len(data) && (data[i+1] == '/' || data[i+1] == '*'):
+			i = skipJSONComment(data, i)
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+var errJSONDocument = errors.New("unexpected staskfile JSON document")
+
+// returns the offset after the value starting at i
+func skipJSONValue(data []byte, i int) (int, error) {
+	if i >= len(data) {
+		return i, errJSONDocument
+	}
+	switch data[i] {
+	case '"':
+		return skipJSONString(data, i), nil
+	case '{', '[':
+		depth := 0
+		for i < len(data) {
+			switch {
+			case data[i] == '"':
+				i = skipJSONString(data, i)
+				continue
+			case data[i] == '/' && i+1 < len(data) && (data[i+1] == '/' || data[i+1] == '*'):
+				i = skipJSONComment(data, i)
+				continue
+			case data[i] == '{' || data[i] == '[':
+				depth++
+			case data[i] == '}' || data[i] == ']':
+				depth--
+				if depth == 0 {
+					return i + 1, nil
+				}
+			}
+			i++
+		}
+		return i, errJSONDocument
+	}
+	start := i
+	for i < len(data) && !strings.ContainsRune(" \t\r\n,}]/", rune(data[i])) {
+		i++
+	}
+	if i == start {
+		return i, errJSONDocument
+	}
+	return i, nil
+}
+
+// a member of a JSON object, as offsets into the document
+type jsonMember struct {
+	key        string
+	start      int
+	valueStart int
+	valueEnd   int
+}
+
+// a JSON object, as offsets into the document
+type jsonObject struct {
+	open    int
+	close   int
+	members []jsonMember
+}
+
+// reads the members of the object starting at open
+func readJSONObject(data []byte, open int) (jsonObject, error) {
+	object := jsonObject{open: open}
+	if open >= len(data) || data[open] != '{' {
+		return object, errJSONDocument
+	}
+
+	i := skipJSONSpace(data, open+1)
+	for i < len(data) && data[i] != '}' {
+		if data[i] != '"' {
+			return object, errJSONDocument
+		}
+		member := jsonMember{start: i}
+		keyEnd := skipJSONString(data, i)
+		member.key = string(data[i+1 : keyEnd-1])
+
+		i = skipJSONSpace(data, keyEnd)
+		if i >= len(data) || data[i] != ':' {
+			return object, errJSONDocument
+		}
+		member.valueStart = skipJSONSpace(data, i+1)
+		end, err := skipJSONValue(data, member.valueStart)
+		if err != nil {
+			return object, err
+		}
+		member.valueEnd = end
+		object.members = append(object.members, member)
+
+		i = skipJSONSpace(data, end)
+		if i < len(data) && data[i] == ',' {
+			i = skipJSONSpace(data, i+1)
+		}
+	}
+	if i >= len(data) {
+		return object, errJSONDocument
+	}
+	object.close = i
+	return object, nil
+}
+
+// returns the member named key, keys of the staskfile fields are matched without case like encoding/json does
+func (object jsonObject) find(key string, foldCase bool) (jsonMember, bool) {
+	for _, member := range object.members {
+		if member.key == key {
+			return member, true
+		}
+	}
+	if foldCase {
+		for _, member := range object.members {
+			if strings.EqualFold(member.key, key) {
+				return member, true
+			}
+		}
+	}
+	return jsonMember{}, false
+}
+
+// returns the whitespace at the start of the line holding offset
+func lineIndent(data []byte, offset int) string {
+	start := bytes.LastIndexByte(data[:offset], '\n') + 1
+	end := start
+	for end < len(data) && (data[end] == ' ' || data[end] == '\t') {
+		end++
+	}
+	return string(data[start:end])
+}
+
+// returns the document with the member key of the object set to value, value is indented to match the object
+// unit is the indentation of a nested object, used when the object has no members yet
+func setJSONMember(data []byte, object jsonObject, key string, value []byte, unit string) []byte {
+	var out []byte
+	if member, found := object.find(key, false); found {
+		value = bytes.ReplaceAll(value, []byte("\n"), []byte("\n"+lineIndent(data, member.start)))
+		out = append(out, data[:member.valueStart]...)
+		out = append(out, value...)
+		return append(out, data[member.valueEnd:]...)
+	}
+
+	quotedKey, _ := json.Marshal(key)
+	if len(object.members) == 0 {
+		indent := lineIndent(data, object.open)
+		value = bytes.ReplaceAll(value, []byte("\n"), []byte("\n"+indent+unit))
+		out = append(out, data[:object.open+1]...)
+		out = append(out, "\n"+indent+unit+string(quotedKey)+": "...)
+		out = append(out, value...)
+		out = append(out, "\n"+indent...)
+		return append(out, data[object.close:]...)
+	}
+
+	last := object.members[len(object.members)-1]
+	indent := lineIndent(data, last.start)
+	value = bytes.ReplaceAll(value, []byte("\n"), []byte("\n"+indent))
+	out = append(out, data[:last.valueEnd]...)
+	out = append(out, ",\n"+indent+string(quotedKey)+": "...)
+	out = append(out, value...)
+	return append(out, data[last.valueEnd:]...)
+}
+
+// returns the document without the member key of the object
+// a member on its own line is removed along with that line, and with a comment following it on the line
+func deleteJSONMember(data []byte, object jsonObject, key string) []byte {
+	for i, member := range object.members {
+		if member.key != key {
+			continue
+		}
+		if len(object.members) == 1 {
+			return concat(data[:object.open+1], data[object.close:])
+		}
+
+		start := member.start
+		if lineStart := bytes.LastIndexByte(data[:start], '\n') + 1; len(bytes.TrimSpace(data[lineStart:start])) == 0 {
+			start = lineStart
+		}
+		end := skipJSONLineSpace(data, member.valueEnd)
+		if end < len(data) && data[end] == ',' {
+			end = skipJSONLineSpace(data, end+1)
+		}
+		if start != member.start && end+1 < len(data) && data[end] == '/' && data[end+1] == '/' {
+			end = skipJSONComment(data, end)
+		}
+		if start != member.start && end < len(data) && data[end] == '\n' {
+			end += 1
+		}
+
+		if i < len(object.members)-1 {
+			return concat(data[:start], data[end:])
+		}
+		// the comma after the previous member goes away with the last one
+		comma := skipJSONSpace(data, object.members[i-1].valueEnd)
+		return concat(data[:comma], data[comma+1:start], data[end:])
+	}
+	return data
+}
+
+// returns the offset of the first character at or after i that is not a space or a tab
+func skipJSONLineSpace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+	return out
+}
+
+// indentation of the JSON written by SerializeStaskfile
+const jsonIndent = "    "
+
+// returns the indentation used by the document, from the first member of its root object
+func jsonIndentOf(data []byte, root jsonObject) string {
+	if len(root.members) > 0 {
+		start := root.members[0].start
+		indent := lineIndent(data, start)
+		if len(indent) > 0 && start == bytes.LastIndexByte(data[:start], '\n')+1+len(indent) {
+			return indent
+		}
+	}
+	return jsonIndent
+}
@@ -0,0 +1,68 @@
+package staskfile
+
+import "path/filepath"
+
+// names of the staskfiles that are merged together, from lowest to highest precedence
+const (
+	LayerGlobal  = "global"
+	LayerProject = "project"
+	LayerLocal   = "local"
+)
+
+// name of the staskfile for personal overrides without its extension, next to the project staskfile and usually ignored by git
+const LocalFileBaseName = ".stask.local"
+
+// returns the path of the local staskfile next to the project staskfile at projectPath
+// an existing local staskfile is used whatever its format, a new one is written in the format of the project staskfile
+func LocalPath(projectPath string) string {
+	dir := filepath.Dir(projectPath)
+	if path, found := FindStaskfile(dir, LocalFileBaseName); found {
+		return path
+	}
+	return filepath.Join(dir, LocalFileBaseName+filepath.Ext(projectPath))
+}
+
+type Layer struct {
+	Name      string
+	Path      string
+	Staskfile Staskfile
+}
+
+// staskfiles merged together, remembering which layer every entry comes from
+type Merged struct {
+	Staskfile
+	// the layers that were merged, from lowest to highest precedence
+	Layers        []Layer
+	TaskLayers    map[string]string
+	StateLayers   map[string]string
+	ProfileLayers map[string]string
+}
+
+// merges layers given from lowest to highest precedence
+// tasks, state and profiles are merged by name, the entry from the highest layer wins
+// a profile is taken whole from its highest layer, its keys are not merged with the ones of lower layers
+func Merge(layers []Layer) Merged {
+	merged := Merged{
+		Staskfile:     Empty(),
+		Layers:        layers,
+		TaskLayers:    map[string]string{},
+		StateLayers:   map[string]string{},
+		ProfileLayers: map[string]string{},
+	}
+
+	for _, layer := range layers {
+		for name, task := range layer.Staskfile.Tasks {
+			merged.Tasks[name] = task
+			merged.TaskLayers[name] = layer.Name
+		}
+		for key, value := range layer.Staskfile.State {
+			merged.State[key] = value
+			merged.StateLayers[key] = layer.Name
+		}
+		for name, profile := range layer.Staskfile.Profiles {
+			merged.Profiles[name] = profile
+			merged.ProfileLayers[name] = layer.Name
+		}
+	}
+	return merged
+}
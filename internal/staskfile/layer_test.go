@@ -0,0 +1,43 @@
+package staskfile_test
+
+import (
+	"testing"
+
+	"github.com/itsfrank/stask/internal/staskfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge(t *testing.T) {
+	global := staskfile.Empty()
+	global.Tasks["build"] = staskfile.Task{Cmd: "make"}
+	global.Tasks["hello"] = staskfile.Task{Cmd: "echo hello"}
+	global.State["editor"] = "vim"
+	global.State["flavor"] = "debug"
+	global.Profiles["release"] = map[string]string{"flavor": "release"}
+
+	project := staskfile.Empty()
+	project.Tasks["build"] = staskfile.Task{Cmd: "cmake --build build"}
+	project.State["flavor"] = "relwithdebinfo"
+	project.Profiles["release"] = map[string]string{"flavor": "release", "lto": "on"}
+
+	local := staskfile.Empty()
+	local.State["flavor"] = "asan"
+
+	merged := staskfile.Merge([]staskfile.Layer{
+		{Name: staskfile.LayerGlobal, Staskfile: global},
+		{Name: staskfile.LayerProject, Staskfile: project},
+		{Name: staskfile.LayerLocal, Staskfile: local},
+	})
+
+	assert.Equal(t, map[string]staskfile.Task{"build": {Cmd: "cmake --build build"}, "hello": {Cmd: "echo hello"}}, merged.Tasks)
+	assert.Equal(t, map[string]string{"build": "project", "hello": "global"}, merged.TaskLayers)
+	assert.Equal(t, map[string]string{"editor": "vim", "flavor": "asan"}, merged.State)
+	assert.Equal(t, map[string]string{"editor": "global", "flavor": "local"}, merged.StateLayers)
+	assert.Equal(t, map[string]map[string]string{"release": {"flavor": "release", "lto": "on"}}, merged.Profiles)
+	assert.Equal(t, map[string]string{"release": "project"}, merged.ProfileLayers)
+}
+
+func TestMergeEmpty(t *testing.T) {
+	merged := staskfile.Merge(nil)
+	assert.Equal(t, staskfile.Empty(), merged.Staskfile)
+}
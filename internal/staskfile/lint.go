@@ -0,0 +1,214 @@
+package staskfile
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/shlex"
+	"github.com/itsfrank/stask/internal/template"
+)
+
+// checks run by Lint, each warning says which one found it
+const (
+	LintUnusedState      = "unused-state"
+	LintMissingState     = "missing-state"
+	LintUnusedProfileKey = "unused-profile-key"
+	LintDuplicateTask    = "duplicate-task"
+	LintDirectSplit      = "direct-split"
+)
+
+// a warning found by Lint, about a staskfile that works but is probably not written as meant
+type Warning struct {
+	// the check that found it, one of the Lint constants
+	Check   string
+	Message string
+}
+
+func (warning Warning) String() string {
+	return fmt.Sprintf("%s: %s", warning.Check, warning.Message)
+}
+
+// returns the warnings found in the staskfile, which should have its includes and layers merged
+// defaultExec is the exec mode of tasks that do not set one
+// templates that cannot be parsed are skipped, Validate reports them
+func (sf Staskfile) Lint(defaultExec string) []Warning {
+	var warnings []Warning
+	warn := func(check string, format string, args ...interface{}) {
+		warnings = append(warnings, Warning{check, fmt.Sprintf(format, args...)})
+	}
+
+	// keys inserted or tested by tasks and state values
+	used := map[string]bool{}
+	useKeys := func(str string) (template.Template, bool) {
+		tmpl, err := template.ParseTemplate(str)
+		if err != nil {
+			return tmpl, false
+		}
+		for _, key := range append(tmpl.Keys, tmpl.CondKeys...) {
+			if len(key.Namespace) == 0 {
+				used[key.Str] = true
+			}
+		}
+		return tmpl, true
+	}
+
+	for _, name := range sortedKeys(sf.Tasks) {
+		task := sf.Tasks[name]
+		var missing []string
+		for _, str := range task.Templates() {
+			tmpl, ok := useKeys(str)
+			if !ok {
+				continue
+			}
+			// a key only inserted inside a conditional section testing it is never missing
+			tested := map[string]bool{}
+			for _, key := range tmpl.CondKeys {
+				tested[key.Name()] = true
+			}
+			for _, key := range tmpl.Keys {
+				if len(key.Namespace) > 0 || key.IsAllArgs() || key.HasDefault || tested[key.Name()] || sf.isSet(key.Str) {
+					continue
+				}
+				if !contains(missing, key.Str) {
+					missing = append(missing, key.Str)
+				}
+			}
+		}
+		for _, key := range missing {
+			warn(LintMissingState, "task '%s' uses '%s', which is not in state or any profile", name, key)
+		}
+
+		exec := task.Exec
+		if len(exec) == 0 {
+			exec = defaultExec
+		}
+		if exec != ExecDirect {
+			continue
+		}
+		for _, step := range task.Commands() {
+			tmpl, err := template.ParseTemplate(step.Cmd)
+			if err != nil {
+				continue
+			}
+			if reason := splitChange(tmpl.Str); len(reason) > 0 {
+				warn(LintDirectSplit, "task '%s' runs without a shell, %s", name, reason)
+			}
+		}
+	}
+	for _, name := range sortedKeys(sf.State) {
+		useKeys(sf.State[name])
+	}
+
+	for _, name := range sortedKeys(sf.State) {
+		if !used[name] {
+			warn(LintUnusedState, "state value '%s' is not used by any task", name)
+		}
+	}
+	for _, profile := range sortedKeys(sf.Profiles) {
+		for _, key := range sortedKeys(sf.Profiles[profile]) {
+			if !used[key] {
+				warn(LintUnusedProfileKey, "profile '%s' sets '%s', which no task uses", profile, key)
+			}
+		}
+	}
+
+	for _, names := range sf.duplicateTasks(defaultExec) {
+		warn(LintDuplicateTask, "tasks '%s' run identical commands", strings.Join(names, "', '"))
+	}
+	return warnings
+}
+
+// whether the key has a value in state or in any profile
+func (sf Staskfile) isSet(key string) bool {
+	if _, found := sf.State[key]; found {
+		return true
+	}
+	for _, profile := range sf.Profiles {
+		if _, found := profile[key]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the names of tasks that run the same commands the same way, grouped and sorted
+func (sf Staskfile) duplicateTasks(defaultExec string) [][]string {
+	groups := map[string][]string{}
+	for _, name := range sortedKeys(sf.Tasks) {
+		task := sf.Tasks[name]
+		steps := task.Commands()
+		if len(steps) == 0 {
+			continue
+		}
+		exec := task.Exec
+		if len(exec) == 0 {
+			exec = defaultExec
+		}
+		// NUL cannot be written in a staskfile, so it separates the parts of the key
+		parts := []string{exec, task.Dir, task.Cwd}
+		for _, env := range sortedKeys(task.Env) {
+			parts = append(parts, env+"="+task.Env[env])
+		}
+		for _, step := range steps {
+			parts = append(parts, fmt.Sprint(step.ContinueOnError), step.Cmd)
+		}
+		key := strings.Join(parts, "\x00")
+		groups[key] = append(groups[key], name)
+	}
+
+	var duplicates [][]string
+	for _, names := range groups {
+		if len(names) > 1 {
+			duplicates = append(duplicates, names)
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool { return duplicates[i][0] < duplicates[j][0] })
+	return duplicates
+}
+
+// describes how splitting a command into arguments with shlex, as tasks without a shell are run,
+// changes what a shell would have run, empty when it does not
+func splitChange(command string) string {
+	if _, err := shlex.Split(command); err != nil {
+		return fmt.Sprintf("its command cannot be split into arguments: %v", err)
+	}
+
+	quote := rune(0)
+	tokenStart := true
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		chr := runes[i]
+		switch {
+		case chr == '\\' && quote != '\'':
+			i++
+		case quote != 0:
+			if chr == quote {
+				quote = 0
+			} else if quote == '"' && (chr == '$' || chr == '`') {
+				return fmt.Sprintf("'%c' in its command is not expanded", chr)
+			}
+		case chr == '\'' || chr == '"':
+			quote = chr
+		case chr == '\n':
+			return "the lines of its command are joined into a single command"
+		case strings.ContainsRune("|&;<>()", chr):
+			return fmt.Sprintf("'%c' in its command is passed as an argument", chr)
+		case strings.ContainsRune("$`*?[", chr):
+			return fmt.Sprintf("'%c' in its command is not expanded", chr)
+		case tokenStart && chr == '~':
+			return "'~' in its command is not expanded"
+		}
+		tokenStart = quote == 0 && (chr == ' ' || chr == '\t')
+	}
+	return ""
+}
+
+func contains(list []string, str string) bool {
+	for _, item := range list {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
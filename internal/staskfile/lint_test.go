@@ -0,0 +1,84 @@
+package staskfile_test
+
+import (
+	"testing"
+
+	"github.com/itsfrank/stask/internal/staskfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	sf := staskfile.Empty()
+	sf.Tasks = map[string]staskfile.Task{
+		"build":   {Cmd: "go build {flags} ./..."},
+		"compile": {Cmd: "go build {flags} ./..."},
+		"test":    {Cmd: "go test {pkg}{if race} -race{end} {count:-1}"},
+		"deploy":  {Cmd: "scp {artifact} {env:HOST} {args}", Cwd: "{dir}"},
+		"all":     {Parallel: []string{"build", "test"}},
+	}
+	sf.State = map[string]string{"flags": "-v", "old": "x", "dir": "{root}/out"}
+	sf.Profiles = map[string]map[string]string{
+		"ci": {"flags": "", "root": "/ci", "verbose": "1"},
+	}
+
+	assert.Equal(t, []staskfile.Warning{
+		{Check: staskfile.LintMissingState, Message: "task 'deploy' uses 'artifact', which is not in state or any profile"},
+		{Check: staskfile.LintMissingState, Message: "task 'test' uses 'pkg', which is not in state or any profile"},
+		{Check: staskfile.LintUnusedState, Message: "state value 'old' is not used by any task"},
+		{Check: staskfile.LintUnusedProfileKey, Message: "profile 'ci' sets 'verbose', which no task uses"},
+		{Check: staskfile.LintDuplicateTask, Message: "tasks 'build', 'compile' run identical commands"},
+	}, sf.Lint(staskfile.ExecShell))
+}
+
+func TestLintDuplicateTasks(t *testing.T) {
+	sf := staskfile.Empty()
+	sf.Tasks = map[string]staskfile.Task{
+		"a":    {Cmd: "make"},
+		"b":    {Steps: []staskfile.Step{{Cmd: "make"}}},
+		"c":    {Cmd: "make", Cwd: "sub"},
+		"d":    {Cmd: "make", Exec: staskfile.ExecDirect},
+		"e":    {Cmd: "make", Env: map[string]string{"CC": "clang"}},
+		"f":    {Steps: []staskfile.Step{{Cmd: "make", ContinueOnError: true}}},
+		"tool": {Cmd: "make", Cwd: "sub"},
+	}
+	assert.Equal(t, []staskfile.Warning{
+		{Check: staskfile.LintDuplicateTask, Message: "tasks 'a', 'b' run identical commands"},
+		{Check: staskfile.LintDuplicateTask, Message: "tasks 'c', 'tool' run identical commands"},
+	}, sf.Lint(staskfile.ExecShell))
+}
+
+func TestLintDirectSplit(t *testing.T) {
+	var tests = []struct {
+		cmd      string
+		expected string
+	}{
+		{"go test ./...", ""},
+		{"echo 'a | b' \"c > d\" e\\;f '$HOME'", ""},
+		{"echo {name|q} a~{user}", ""},
+		{"gofmt -l . | wc -l", "'|' in its command is passed as an argument"},
+		{"make && make install", "'&' in its command is passed as an argument"},
+		{"echo > out.txt", "'>' in its command is passed as an argument"},
+		{"echo $HOME", "'$' in its command is not expanded"},
+		{"echo \"$HOME\"", "'$' in its command is not expanded"},
+		{"rm *.o", "'*' in its command is not expanded"},
+		{"ls ~", "'~' in its command is not expanded"},
+		{"make\nmake install", "the lines of its command are joined into a single command"},
+		{"echo 'unterminated", "its command cannot be split into arguments: EOF found when expecting closing quote"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			sf := staskfile.Empty()
+			sf.Tasks = map[string]staskfile.Task{"task": {Cmd: tt.cmd}}
+			sf.State = map[string]string{"name": "x", "user": "y"}
+			sf.Tasks["uses"] = staskfile.Task{Cmd: "{name}{user}", Exec: staskfile.ExecShell}
+
+			var expected []staskfile.Warning
+			if len(tt.expected) > 0 {
+				expected = []staskfile.Warning{{Check: staskfile.LintDirectSplit, Message: "task 'task' runs without a shell, " + tt.expected}}
+			}
+			assert.Equal(t, expected, sf.Lint(staskfile.ExecDirect))
+			// tasks run in a shell get their command as it is written
+			assert.Empty(t, sf.Lint(staskfile.ExecShell))
+		})
+	}
+}
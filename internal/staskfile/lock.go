@@ -0,0 +1,43 @@
+package staskfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// an advisory lock on a staskfile, held by stask commands while they read, change and write it back
+// the lock is taken on a file next to the staskfile, the staskfile itself is replaced when it is written
+type Lock struct {
+	file *os.File
+}
+
+// returns the path of the file locked for the staskfile at path, a hidden file named after it
+// like .staskfile.json.lock so .gitignore can name it without ignoring other .lock files
+func LockPath(path string) string {
+	dir, name := filepath.Split(path)
+	return filepath.Join(dir, "."+strings.TrimPrefix(name, ".")+".lock")
+}
+
+// waits until no other stask command holds the lock on the staskfile at path, and takes it
+// the lock is released by Unlock, or when the process exits
+func LockStaskfile(path string) (*Lock, error) {
+	file, err := os.OpenFile(LockPath(path), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &Lock{file}, nil
+}
+
+func (lock *Lock) Unlock() error {
+	err := unlockFile(lock.file)
+	closeErr := lock.file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
@@ -0,0 +1,82 @@
+package staskfile_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/itsfrank/stask/internal/staskfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrentUpdatesAreNotLost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "staskfile.json")
+	assert.Nil(t, staskfile.WriteStaskfile(path, staskfile.Empty()))
+
+	const count = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lock, err := staskfile.LockStaskfile(path)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer lock.Unlock()
+
+			sf, err := staskfile.ReadStaskfileNoIncludes(path)
+			if err != nil {
+				errs <- err
+				return
+			}
+			sf.State[fmt.Sprintf("key%d", i)] = fmt.Sprint(i)
+			errs <- staskfile.UpdateStaskfile(path, sf)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.Nil(t, err)
+	}
+
+	sf, err := staskfile.ReadStaskfileNoIncludes(path)
+	assert.Nil(t, err)
+	assert.Len(t, sf.State, count)
+	for i := 0; i < count; i++ {
+		assert.Equal(t, fmt.Sprint(i), sf.State[fmt.Sprintf("key%d", i)])
+	}
+
+	// only the staskfile and its lock are left, temporary files are renamed over the staskfile
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestLockPath(t *testing.T) {
+	dir := t.TempDir()
+	assert.Equal(t, filepath.Join(dir, ".staskfile.json.lock"), staskfile.LockPath(filepath.Join(dir, "staskfile.json")))
+	assert.Equal(t, filepath.Join(dir, ".stask.local.yaml.lock"), staskfile.LockPath(filepath.Join(dir, ".stask.local.yaml")))
+}
+
+func TestWriteStaskfilePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows files do not have unix permissions")
+	}
+	path := filepath.Join(t.TempDir(), "staskfile.json")
+	assert.Nil(t, staskfile.WriteStaskfile(path, staskfile.Empty()))
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	assert.Nil(t, os.Chmod(path, 0600))
+	assert.Nil(t, staskfile.WriteStaskfile(path, staskfile.Empty()))
+	info, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
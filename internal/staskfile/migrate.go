@@ -0,0 +1,343 @@
+package staskfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// the version of the staskfile format written by this stask, older staskfiles are upgraded in memory when they
+// are read, and on disk when stask writes to them or by UpgradeStaskfile
+const CurrentVersion = 1
+
+// upgrades a staskfile from the version before it
+type migration struct {
+	// changes the decoded document, its values are those decoded from JSON
+	migrate func(doc map[string]interface{})
+	// makes the same change to the staskfile as written, keeping its comments and order
+	// returns false when it cannot, see UpgradeStaskfile
+	edit func(data []byte, format string) ([]byte, bool)
+}
+
+// migrations[i] upgrades a staskfile from version i to version i+1
+var migrations = []migration{
+	// version 0 has no "Version", encoding/json matched its fields without case so "tasks" was read as "Tasks"
+	{migrateFieldCase, editFieldCase},
+}
+
+// names of the fields of a staskfile as they are written
+var fieldNames = []string{"Version", "Include", "Tasks", "State", "Profiles"}
+
+// returned for a staskfile written for a newer stask
+type VersionError struct {
+	Version int
+}
+
+func (err *VersionError) Error() string {
+	return fmt.Sprintf("staskfile version %d is newer than this stask understands (up to version %d), update stask to use it", err.Version, CurrentVersion)
+}
+
+// returns the staskfile upgraded to CurrentVersion, along with the version it is written in
+func parseVersioned(data []byte, format string) (Staskfile, int, error) {
+	doc, err := decodeDocument(data, format)
+	if err != nil {
+		return Empty(), 0, formatError(data, format, err)
+	}
+	version, err := documentVersion(doc)
+	if err != nil {
+		return Empty(), 0, err
+	}
+	if version > CurrentVersion {
+		return Empty(), version, &VersionError{version}
+	}
+	if version == CurrentVersion {
+		sf, err := parseFormat(data, format)
+		return sf, version, err
+	}
+
+	for _, migration := range migrations[version:] {
+		migration.migrate(doc)
+	}
+	doc["Version"] = CurrentVersion
+	converted, err := json.Marshal(doc)
+	if err != nil {
+		return Empty(), version, err
+	}
+	var sf Staskfile
+	if err := json.Unmarshal(converted, &sf); err != nil {
+		return Empty(), version, formatError(data, format, err)
+	}
+	return initialized(sf), version, nil
+}
+
+// errors are given by the parser of the format when it can, with their position in the staskfile
+func formatError(data []byte, format string, err error) error {
+	if _, parseErr := parseFormat(data, format); parseErr != nil {
+		return parseErr
+	}
+	return err
+}
+
+// decodes the staskfile without its types, to read its version and migrate it
+func decodeDocument(data []byte, format string) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	var err error
+	switch format {
+	case FormatYAML:
+		err = yaml.Unmarshal(data, &doc)
+	case FormatTOML:
+		_, err = toml.Decode(string(data), &doc)
+	default:
+		err = json.Unmarshal(stripJSONComments(data), &doc)
+	}
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	return doc, err
+}
+
+// returns the "Version" of the document, 0 when it has none
+func documentVersion(doc map[string]interface{}) (int, error) {
+	var value interface{}
+	for key, v := range doc {
+		if strings.EqualFold(key, "Version") {
+			value = v
+		}
+	}
+
+	var number float64
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		number = v
+	case int:
+		number = float64(v)
+	case int64:
+		number = float64(v)
+	default:
+		return 0, fmt.Errorf("staskfile \"Version\" must be a number, found %v", value)
+	}
+	if number < 0 || number != math.Trunc(number) {
+		return 0, fmt.Errorf("staskfile \"Version\" must be a whole number, found %v", value)
+	}
+	return int(number), nil
+}
+
+// reads the staskfile at path, an older staskfile is only upgraded in memory, see UpgradeStaskfile
+func readStaskfileFile(path string) (Staskfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Empty(), err
+	}
+	sf, _, err := parseStaskfileData(path, data)
+	return sf, err
+}
+
+func parseStaskfileData(path string, data []byte) (Staskfile, int, error) {
+	sf, version, err := parseVersioned(data, FormatOf(path))
+	if err != nil {
+		var versionErr *VersionError
+		if errors.As(err, &versionErr) {
+			return Empty(), version, fmt.Errorf("staskfile '%s': %w", path, err)
+		}
+		return Empty(), version, newParseError(path, err)
+	}
+	return sf, version, nil
+}
+
+// returns the path of the backup made of the staskfile at path before upgrading it from version
+func BackupPath(path string, version int) string {
+	return fmt.Sprintf("%s.v%d.bak", path, version)
+}
+
+// upgrades the staskfile at path to CurrentVersion on disk, after making a backup of it, see BackupPath
+// returns the version it was written in, nothing is written when that is CurrentVersion
+// JSON and YAML staskfiles are upgraded in place when every migration can do it, they are written whole otherwise
+func UpgradeStaskfile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	sf, version, err := parseStaskfileData(path, data)
+	if err != nil || version == CurrentVersion {
+		return version, err
+	}
+
+	format := FormatOf(path)
+	if err := backupStaskfile(path, data, version); err != nil {
+		return version, err
+	}
+	if upgraded, ok := upgradeDocumentAs(data, format, version, sf); ok {
+		return version, writeStaskfileData(path, upgraded)
+	}
+	return version, WriteStaskfile(path, sf)
+}
+
+// keeps the staskfile as it was before upgrading it from version, an existing backup is not replaced
+func backupStaskfile(path string, data []byte, version int) error {
+	backup := BackupPath(path, version)
+	if _, err := os.Stat(backup); !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return writeStaskfileData(backup, data)
+}
+
+// returns the document upgraded in place, false when it cannot be or when it would not read as sf
+func upgradeDocumentAs(data []byte, format string, version int, sf Staskfile) ([]byte, bool) {
+	upgraded, ok := upgradeDocument(data, format, version)
+	if !ok {
+		return nil, false
+	}
+	read, _, err := parseVersioned(upgraded, format)
+	return upgraded, err == nil && reflect.DeepEqual(read, sf)
+}
+
+func upgradeDocument(data []byte, format string, version int) ([]byte, bool) {
+	if format != FormatJSON && format != FormatYAML {
+		return nil, false
+	}
+	for _, migration := range migrations[version:] {
+		var ok bool
+		data, ok = migration.edit(data, format)
+		if !ok {
+			return nil, false
+		}
+	}
+	return setDocumentVersion(data, format)
+}
+
+// sets "Version" to CurrentVersion, a new "Version" is the first field of the staskfile
+func setDocumentVersion(data []byte, format string) ([]byte, bool) {
+	value := strconv.Itoa(CurrentVersion)
+	if format == FormatJSON {
+		root, err := readJSONObject(data, skipJSONSpace(data, 0))
+		if err != nil {
+			return nil, false
+		}
+		if _, found := root.find("Version", false); found || len(root.members) == 0 {
+			return setJSONMember(data, root, "Version", []byte(value), jsonIndentOf(data, root)), true
+		}
+		// before the comments above the first field too
+		first := root.open + 1
+		for first < len(data) && strings.ContainsRune(" \t\r\n", rune(data[first])) {
+			first++
+		}
+		if lineStart := bytes.LastIndexByte(data[:first], '\n') + 1; len(bytes.TrimSpace(data[lineStart:first])) == 0 {
+			indent := string(data[lineStart:first])
+			return concat(data[:lineStart], []byte(indent+`"Version": `+value+",\n"), data[lineStart:]), true
+		}
+		return concat(data[:first], []byte(`"Version": `+value+", "), data[first:]), true
+	}
+
+	root, ok := yamlRoot(data)
+	if !ok {
+		return nil, false
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	for i := 0; i < len(root.Content); i += 2 {
+		key, node := root.Content[i], root.Content[i+1]
+		if key.Value != "Version" {
+			continue
+		}
+		if node.Kind != yaml.ScalarNode || node.Line != key.Line {
+			return nil, false
+		}
+		lines[key.Line-1] = "Version: " + value + "\n"
+		return []byte(strings.Join(lines, "")), true
+	}
+	return append([]byte("Version: "+value+"\n"), data...), true
+}
+
+// returns the top-level mapping of a YAML staskfile written as a block
+func yamlRoot(data []byte) (*yaml.Node, bool) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, false
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) != 1 {
+		return nil, false
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode || root.Style&yaml.FlowStyle != 0 {
+		return nil, false
+	}
+	return root, true
+}
+
+// renames fields written in another case to their name, unless the staskfile also has the field as it is named
+func migrateFieldCase(doc map[string]interface{}) {
+	for key, value := range doc {
+		for _, name := range fieldNames {
+			if key == name || !strings.EqualFold(key, name) {
+				continue
+			}
+			if _, found := doc[name]; !found {
+				doc[name] = value
+				delete(doc, key)
+			}
+		}
+	}
+}
+
+func editFieldCase(data []byte, format string) ([]byte, bool) {
+	renamed := func(key string, keys map[string]bool) (string, bool) {
+		for _, name := range fieldNames {
+			if key != name && strings.EqualFold(key, name) && !keys[name] {
+				return name, true
+			}
+		}
+		return "", false
+	}
+
+	if format == FormatJSON {
+		root, err := readJSONObject(data, skipJSONSpace(data, 0))
+		if err != nil {
+			return nil, false
+		}
+		keys := map[string]bool{}
+		for _, member := range root.members {
+			keys[member.key] = true
+		}
+		// from the last member so the offsets of the others stay valid
+		for i := len(root.members) - 1; i >= 0; i-- {
+			member := root.members[i]
+			if name, ok := renamed(member.key, keys); ok {
+				data = concat(data[:member.start], []byte(`"`+name+`"`), data[member.start+len(member.key)+2:])
+			}
+		}
+		return data, true
+	}
+
+	root, ok := yamlRoot(data)
+	if !ok {
+		return nil, false
+	}
+	keys := map[string]bool{}
+	for i := 0; i < len(root.Content); i += 2 {
+		keys[root.Content[i].Value] = true
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	for i := 0; i < len(root.Content); i += 2 {
+		key := root.Content[i]
+		name, ok := renamed(key.Value, keys)
+		if !ok {
+			continue
+		}
+		line := lines[key.Line-1]
+		if key.Style != 0 || key.Column != 1 || !strings.HasPrefix(line, key.Value) {
+			return nil, false
+		}
+		lines[key.Line-1] = name + line[len(key.Value):]
+	}
+	return []byte(strings.Join(lines, "")), true
+}
@@ -0,0 +1,144 @@
+package staskfile_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itsfrank/stask/internal/staskfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateSample(t *testing.T) {
+	original, err := os.ReadFile(filepath.Join("..", "..", "test", "testdata", "sample.json"))
+	assert.Nil(t, err)
+	path := filepath.Join(t.TempDir(), "staskfile.json")
+	assert.Nil(t, os.WriteFile(path, original, 0644))
+
+	sf, err := staskfile.ReadStaskfileNoIncludes(path)
+	assert.Nil(t, err)
+	assert.Equal(t, staskfile.CurrentVersion, sf.Version)
+	assert.Equal(t, staskfile.Task{Cmd: "echo 'this is hello'"}, sf.Tasks["hello"])
+	assert.Equal(t, map[string]string{"foo": "foo-state", "bar": "bar-state"}, sf.State)
+
+	// reading only upgrades in memory
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, original, data)
+
+	version, err := staskfile.UpgradeStaskfile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, version)
+	backup, err := os.ReadFile(staskfile.BackupPath(path, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, original, backup)
+
+	// fields are renamed in place, tasks keep their order
+	upgraded, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, `{
+  "Version": 1,
+  "Tasks": {
+    "hello": "echo 'this is hello'",
+    "foo-task": "echo 'foo state is {foo-state}'",
+    "foo-bar-task": "echo 'foo:bar state is {foo-state}:{bar-state}'"
+  },
+  "State": {
+    "foo": "foo-state",
+    "bar": "bar-state"
+  }
+}
+`, string(upgraded))
+
+	read, err := staskfile.ReadStaskfileNoIncludes(path)
+	assert.Nil(t, err)
+	assert.Equal(t, sf, read)
+
+	version, err = staskfile.UpgradeStaskfile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, staskfile.CurrentVersion, version)
+}
+
+func TestMigrateYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "staskfile.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte("# my tasks\ntasks:\n  b: echo b # bee\n  a: echo a\nstate:\n  x: y\n"), 0644))
+
+	sf, err := staskfile.ReadStaskfileNoIncludes(path)
+	assert.Nil(t, err)
+	assert.Equal(t, staskfile.Task{Cmd: "echo b"}, sf.Tasks["b"])
+	assert.Equal(t, map[string]string{"x": "y"}, sf.State)
+
+	// writing to an older staskfile upgrades it along with the change
+	sf.State["x"] = "z"
+	assert.Nil(t, staskfile.UpdateStaskfile(path, sf))
+	upgraded, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "Version: 1\n# my tasks\nTasks:\n  b: echo b # bee\n  a: echo a\nState:\n  x: z\n", string(upgraded))
+	_, err = os.Stat(staskfile.BackupPath(path, 0))
+	assert.Nil(t, err)
+}
+
+func TestMigrateTOML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "staskfile.toml")
+	original := "# my tasks\n[tasks]\nhello = \"echo hello\"\n"
+	assert.Nil(t, os.WriteFile(path, []byte(original), 0644))
+
+	sf, err := staskfile.ReadStaskfileNoIncludes(path)
+	assert.Nil(t, err)
+	assert.Equal(t, staskfile.Task{Cmd: "echo hello"}, sf.Tasks["hello"])
+
+	// TOML staskfiles are written whole
+	_, err = staskfile.UpgradeStaskfile(path)
+	assert.Nil(t, err)
+	read, err := staskfile.ReadStaskfileNoIncludes(path)
+	assert.Nil(t, err)
+	assert.Equal(t, sf, read)
+	backup, err := os.ReadFile(staskfile.BackupPath(path, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, original, string(backup))
+}
+
+func TestNewerVersionIsRefused(t *testing.T) {
+	_, err := staskfile.ParseStaskfile([]byte(`{"Version": 99, "Tasks": {}}`))
+	var versionErr *staskfile.VersionError
+	assert.True(t, errors.As(err, &versionErr))
+	assert.Equal(t, 99, versionErr.Version)
+
+	path := filepath.Join(t.TempDir(), "staskfile.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"Version": 99}`), 0644))
+	_, err = staskfile.ReadStaskfile(path)
+	assert.EqualError(t, err, "staskfile '"+path+"': staskfile version 99 is newer than this stask understands (up to version 1), update stask to use it")
+
+	_, err = staskfile.ParseStaskfile([]byte(`{"Version": "one"}`))
+	assert.EqualError(t, err, "staskfile \"Version\" must be a number, found one")
+}
+
+func TestReadStaskfilesAreNotUpgraded(t *testing.T) {
+	dir := t.TempDir()
+	common := []byte(`{"tasks": {"build": "make"}}`)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "common.json"), common, 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "staskfile.json"), []byte(`{"Version": 1, "Include": ["common.json"]}`), 0644))
+
+	sf, err := staskfile.ReadStaskfile(filepath.Join(dir, "staskfile.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, staskfile.Task{Cmd: "make"}, sf.Tasks["common:build"])
+
+	data, err := os.ReadFile(filepath.Join(dir, "common.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, common, data)
+	_, err = os.Stat(staskfile.BackupPath(filepath.Join(dir, "common.json"), 0))
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	// neither is the staskfile itself
+	project := []byte(`{"include": ["common.json"], "tasks": {"test": "make test"}}`)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "staskfile.json"), project, 0644))
+	sf, err = staskfile.ReadStaskfile(filepath.Join(dir, "staskfile.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, staskfile.Task{Cmd: "make test"}, sf.Tasks["test"])
+	data, err = os.ReadFile(filepath.Join(dir, "staskfile.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, project, data)
+	_, err = os.Stat(staskfile.BackupPath(filepath.Join(dir, "staskfile.json"), 0))
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
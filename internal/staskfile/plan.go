@@ -0,0 +1,80 @@
+package staskfile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// returned when tasks depend on each other in a loop
+type DependencyCycleError struct {
+	// the chain of dependencies, starting and ending with the same task
+	Chain []string
+}
+
+func (err *DependencyCycleError) Error() string {
+	return "tasks depend on each other in a cycle: " + strings.Join(err.Chain, " -> ")
+}
+
+// returns the tasks to run, in order, for the named task to run after all of its dependencies
+// every task appears once, even when several tasks depend on it
+// tasks in a parallel group are not part of the plan, they run with the group, but their dependencies run before it
+func (sf Staskfile) Plan(name string) ([]string, error) {
+	var plan []string
+	planned := map[string]bool{}
+	var chain []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		if planned[name] {
+			return nil
+		}
+		for i, other := range chain {
+			if other == name {
+				cycle := append([]string{}, chain[i:]...)
+				return &DependencyCycleError{append(cycle, name)}
+			}
+		}
+
+		task, found := sf.Tasks[name]
+		if !found {
+			if len(chain) == 0 {
+				return fmt.Errorf("task '%s' was not found in staskfile", name)
+			}
+			return fmt.Errorf("task '%s' (dependency of '%s') was not found in staskfile", name, chain[len(chain)-1])
+		}
+
+		chain = append(chain, name)
+		for _, dep := range task.Deps {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		for _, child := range task.Parallel {
+			childTask, found := sf.Tasks[child]
+			if !found {
+				return fmt.Errorf("task '%s' (in parallel group '%s') was not found in staskfile", child, name)
+			}
+			if len(childTask.Parallel) > 0 {
+				return fmt.Errorf("parallel group '%s' cannot be run inside parallel group '%s'", child, name)
+			}
+
+			chain = append(chain, child)
+			for _, dep := range childTask.Deps {
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+			chain = chain[:len(chain)-1]
+		}
+		chain = chain[:len(chain)-1]
+
+		planned[name] = true
+		plan = append(plan, name)
+		return nil
+	}
+
+	if err := visit(name); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
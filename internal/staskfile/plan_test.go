@@ -0,0 +1,68 @@
+package staskfile_test
+
+import (
+	"testing"
+
+	"github.com/itsfrank/stask/internal/staskfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanHappy(t *testing.T) {
+	sf := staskfile.Empty()
+	sf.Tasks["configure"] = staskfile.Task{Cmd: "cmake .."}
+	sf.Tasks["codegen"] = staskfile.Task{Cmd: "gen", Deps: []string{"configure"}}
+	sf.Tasks["build"] = staskfile.Task{Cmd: "make", Deps: []string{"configure", "codegen"}}
+	sf.Tasks["test"] = staskfile.Task{Cmd: "ctest", Deps: []string{"build", "codegen"}}
+	sf.Tasks["frontend"] = staskfile.Task{Cmd: "npm run dev", Deps: []string{"codegen"}}
+	sf.Tasks["backend"] = staskfile.Task{Cmd: "go run .", Deps: []string{"build"}}
+	sf.Tasks["dev"] = staskfile.Task{Parallel: []string{"frontend", "backend"}}
+
+	var tests = []struct {
+		task string
+		plan []string
+	}{
+		{"configure", []string{"configure"}},
+		{"build", []string{"configure", "codegen", "build"}},
+		{"test", []string{"configure", "codegen", "build", "test"}},
+		{"dev", []string{"configure", "codegen", "build", "dev"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.task, func(t *testing.T) {
+			plan, err := sf.Plan(tt.task)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.plan, plan)
+		})
+	}
+}
+
+func TestPlanError(t *testing.T) {
+	sf := staskfile.Empty()
+	sf.Tasks["a"] = staskfile.Task{Cmd: "a", Deps: []string{"b"}}
+	sf.Tasks["b"] = staskfile.Task{Cmd: "b", Deps: []string{"c"}}
+	sf.Tasks["c"] = staskfile.Task{Cmd: "c", Deps: []string{"a"}}
+	sf.Tasks["self"] = staskfile.Task{Cmd: "self", Deps: []string{"self"}}
+	sf.Tasks["broken"] = staskfile.Task{Cmd: "broken", Deps: []string{"missing"}}
+	sf.Tasks["group"] = staskfile.Task{Parallel: []string{"self", "missing"}}
+	sf.Tasks["nested"] = staskfile.Task{Parallel: []string{"group"}}
+	sf.Tasks["child"] = staskfile.Task{Cmd: "child", Deps: []string{"loop"}}
+	sf.Tasks["loop"] = staskfile.Task{Parallel: []string{"child"}}
+
+	var tests = []struct {
+		task string
+		err  string
+	}{
+		{"a", "tasks depend on each other in a cycle: a -> b -> c -> a"},
+		{"self", "tasks depend on each other in a cycle: self -> self"},
+		{"broken", "task 'missing' (dependency of 'broken') was not found in staskfile"},
+		{"missing", "task 'missing' was not found in staskfile"},
+		{"group", "tasks depend on each other in a cycle: self -> self"},
+		{"nested", "parallel group 'group' cannot be run inside parallel group 'nested'"},
+		{"loop", "tasks depend on each other in a cycle: loop -> child -> loop"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.task, func(t *testing.T) {
+			_, err := sf.Plan(tt.task)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package staskfile
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// the JSON Schema draft used by Schema
+const schemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// types written in more than one form, such as a task written as a command string or as an object
+// their schema lists every form, object is the schema generated for the struct
+type schemaForms interface {
+	schemaForms(object map[string]interface{}) map[string]interface{}
+}
+
+// returns a JSON Schema of staskfiles, generated from the Staskfile type
+// editors use it to complete and check staskfiles, see SchemaKey
+func Schema() ([]byte, error) {
+	defs := map[string]interface{}{}
+	root := structSchema(reflect.TypeOf(Staskfile{}), defs)
+	root["properties"].(map[string]interface{})[SchemaKey] = map[string]interface{}{"type": "string"}
+	root["properties"].(map[string]interface{})["Version"] = map[string]interface{}{"type": "integer", "minimum": 0, "maximum": CurrentVersion}
+
+	schema := map[string]interface{}{
+		"$schema": schemaDraft,
+		"title":   "staskfile",
+		"$defs":   defs,
+	}
+	for key, value := range root {
+		schema[key] = value
+	}
+	return json.MarshalIndent(schema, "", jsonIndent)
+}
+
+func typeSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), defs)}
+	case reflect.Struct:
+		// structs are defined once and referenced where they are used
+		if _, found := defs[t.Name()]; !found {
+			defs[t.Name()] = true
+			object := structSchema(t, defs)
+			if forms, ok := reflect.Zero(t).Interface().(schemaForms); ok {
+				defs[t.Name()] = forms.schemaForms(object)
+			} else {
+				defs[t.Name()] = object
+			}
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// returns the schema of a struct written as an object, with a property for each field named by its json tag
+func structSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type, defs)
+		if field.Type.Kind() == reflect.String && !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (Task) schemaForms(object map[string]interface{}) map[string]interface{} {
+	properties := object["properties"].(map[string]interface{})
+	properties["exec"].(map[string]interface{})["enum"] = []string{ExecShell, ExecDirect}
+	object["not"] = map[string]interface{}{"required": []string{"cmd", "steps"}}
+	return map[string]interface{}{"oneOf": []interface{}{
+		map[string]interface{}{"type": "string"},
+		properties["steps"],
+		object,
+	}}
+}
+
+func (Step) schemaForms(object map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"oneOf": []interface{}{map[string]interface{}{"type": "string"}, object}}
+}
+
+func (Include) schemaForms(object map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"oneOf": []interface{}{map[string]interface{}{"type": "string"}, object}}
+}
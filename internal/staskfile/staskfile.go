@@ -2,35 +2,57 @@ package staskfile
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/itsfrank/stask/internal/jsonerror"
 	"os"
+	"path/filepath"
 )
 
+// written in JSON, YAML or TOML, see FormatOf
 type Staskfile struct {
-	Tasks    map[string]string
-	State    map[string]string
-	Profiles map[string]map[string]string
+	// the version of the staskfile format, see CurrentVersion
+	Version int `json:",omitempty" yaml:"Version,omitempty"`
+	// other staskfiles whose tasks are added to this one, see ReadStaskfile
+	Include  []Include                    `json:",omitempty" yaml:"Include,omitempty"`
+	Tasks    map[string]Task              `yaml:"Tasks"`
+	State    map[string]string            `yaml:"State"`
+	Profiles map[string]map[string]string `yaml:"Profiles"`
 }
 
 func Empty() Staskfile {
-	sf := Staskfile{}
-	sf.Tasks = map[string]string{}
+	sf := Staskfile{Version: CurrentVersion}
+	sf.Tasks = map[string]Task{}
 	sf.State = map[string]string{}
 	sf.Profiles = map[string]map[string]string{}
 	return sf
 }
 
+// JSON staskfiles can have comments, see stripJSONComments
+// older staskfiles are upgraded to CurrentVersion, see ParseStaskfileFormat
 func ParseStaskfile(data []byte) (Staskfile, error) {
+	return ParseStaskfileFormat(data, FormatJSON)
+}
+
+func parseJSON(data []byte) (Staskfile, error) {
 	var staskfile Staskfile
-	err := json.Unmarshal(data, &staskfile)
+	err := json.Unmarshal(stripJSONComments(data), &staskfile)
 
 	if err != nil {
+		var syntaxErr *json.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			if taskErr := findJSONTaskError(data); taskErr != nil {
+				return Empty(), taskErr
+			}
+		}
 		return Empty(), jsonerror.GetFormattedError(string(data), err)
 	}
+	return initialized(staskfile), nil
+}
 
-	// make sure all maps are initialized
+// makes sure all maps are initialized
+func initialized(staskfile Staskfile) Staskfile {
 	if staskfile.Tasks == nil {
-		staskfile.Tasks = map[string]string{}
+		staskfile.Tasks = map[string]Task{}
 	}
 	if staskfile.State == nil {
 		staskfile.State = map[string]string{}
@@ -38,26 +60,103 @@ func ParseStaskfile(data []byte) (Staskfile, error) {
 	if staskfile.Profiles == nil {
 		staskfile.Profiles = map[string]map[string]string{}
 	}
-
-	return staskfile, nil
+	return staskfile
 }
 
+// staskfiles are always written in CurrentVersion
 func SerializeStaskfile(staskfile Staskfile) ([]byte, error) {
-	return json.MarshalIndent(staskfile, "", "    ")
+	staskfile.Version = CurrentVersion
+	return json.MarshalIndent(staskfile, "", jsonIndent)
+}
+
+// returned when a staskfile that was read cannot be parsed
+// the message is the one of the parser, Path is not part of it
+type ParseError struct {
+	Path string
+	// the position of the error, counted from 1, 0 when the parser does not give it
+	Line   int
+	Column int
+	Err    error
+}
+
+func (err *ParseError) Error() string {
+	return err.Err.Error()
 }
 
+func (err *ParseError) Unwrap() error {
+	return err.Err
+}
+
+func newParseError(path string, err error) *ParseError {
+	parseErr := &ParseError{Path: path, Err: err}
+	var positionErr *jsonerror.PositionError
+	if errors.As(err, &positionErr) {
+		parseErr.Line = positionErr.Line
+		parseErr.Column = positionErr.Character
+	}
+	return parseErr
+}
+
+// reads the staskfile and resolves its includes recursively
+// included tasks are named after the namespace of their include, e.g. common:build
 func ReadStaskfile(path string) (Staskfile, error) {
-	data, err := os.ReadFile(path)
+	return readIncluding(path, nil)
+}
+
+// reads the staskfile without resolving its includes, to change it and write it back
+// a staskfile older than CurrentVersion is upgraded in memory, UpdateStaskfile upgrades it on disk
+func ReadStaskfileNoIncludes(path string) (Staskfile, error) {
+	return readStaskfileFile(path)
+}
+
+// writes the whole staskfile, see UpdateStaskfile to keep the document as it was written
+func WriteStaskfile(path string, staskfile Staskfile) error {
+	data, err := SerializeStaskfileFormat(staskfile, FormatOf(path))
 	if err != nil {
-		return Empty(), err
+		return err
 	}
-	return ParseStaskfile(data)
+	return writeStaskfileData(path, data)
 }
 
-func WriteStaskfile(path string, staskfile Staskfile) error {
-	data, err := SerializeStaskfile(staskfile)
+// writes to a temporary file next to the staskfile and renames it over the staskfile,
+// so a crash or another stask command never sees a partly written staskfile
+// a symlinked staskfile is written through the link, an existing staskfile keeps its permissions
+func writeStaskfileData(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempPath, mode)
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
 	if err != nil {
+		os.Remove(tempPath)
 		return err
 	}
-	return os.WriteFile(path, data, 0777)
+
+	// makes the rename durable, not supported everywhere so errors are ignored
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
 }
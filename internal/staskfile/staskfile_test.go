@@ -18,8 +18,22 @@ func TestStaskfileRoundTripHappy(t *testing.T) {
 		{
 			"OneTaskOneState.json",
 			staskfile.Staskfile{
-				Tasks: map[string]string{"hello": "hello task"},
-				State: map[string]string{"state": "foo"},
+				Version:  staskfile.CurrentVersion,
+				Tasks:    map[string]staskfile.Task{"hello": {Cmd: "hello task"}},
+				State:    map[string]string{"state": "foo"},
+				Profiles: map[string]map[string]string{},
+			},
+		},
+		{
+			"StructuredTask.json",
+			staskfile.Staskfile{
+				Version: staskfile.CurrentVersion,
+				Tasks: map[string]staskfile.Task{
+					"hello": {Cmd: "hello task"},
+					"build": {Cmd: "make", Desc: "build it", Cwd: "{repo}/sub", Env: map[string]string{"CC": "{cc}"}},
+				},
+				State:    map[string]string{"repo": "~/src/repo", "cc": "clang"},
+				Profiles: map[string]map[string]string{"gcc": {"cc": "gcc"}},
 			},
 		},
 	}
@@ -0,0 +1,239 @@
+package staskfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// a task can be written as a plain command string, a list of steps, or as an object when it needs more than that:
+//
+//	"build": "make {target}"
+//	"build": ["cmake -B build", "cmake --build build"]
+//	"build": {"cmd": "make {target}", "desc": "build the project", "cwd": "{repo}", "env": {"CC": "{cc}"}}
+type Task struct {
+	Cmd string `json:"cmd,omitempty" yaml:"cmd,omitempty"`
+	// commands run in order instead of Cmd, see Task.Commands
+	Steps []Step            `json:"steps,omitempty" yaml:"steps,omitempty"`
+	Desc  string            `json:"desc,omitempty" yaml:"desc,omitempty"`
+	Cwd   string            `json:"cwd,omitempty" yaml:"cwd,omitempty"`
+	Env   map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	// tasks that run before this one, see Staskfile.Plan
+	Deps []string `json:"deps,omitempty" yaml:"deps,omitempty"`
+	// tasks run at the same time instead of Cmd, the task fails if any of them fail
+	Parallel []string `json:"parallel,omitempty" yaml:"parallel,omitempty"`
+	// stop the other parallel tasks as soon as one fails
+	FailFast bool `json:"fail_fast,omitempty" yaml:"fail_fast,omitempty"`
+	// how commands are run, ExecShell or ExecDirect, empty for the default
+	Exec string `json:"exec,omitempty" yaml:"exec,omitempty"`
+	// the directory a relative Cwd is relative to, set on included tasks with a Cwd to the directory of their staskfile
+	// empty for the other tasks, their relative Cwd is relative to where stask is run
+	Dir string `json:"-" yaml:"-"`
+}
+
+const (
+	// commands are run by the user's shell
+	ExecShell = "shell"
+	// commands are split into arguments and run without a shell
+	ExecDirect = "direct"
+)
+
+// a step can be written as a plain command string, or as an object to keep going when it fails:
+//
+//	"make clean"
+//	{"cmd": "make clean", "continue_on_error": true}
+type Step struct {
+	Cmd             string `json:"cmd" yaml:"cmd"`
+	ContinueOnError bool   `json:"continue_on_error,omitempty" yaml:"continue_on_error,omitempty"`
+}
+
+// returns the commands of the task in the order they run, a task with a single command has a single step
+func (task Task) Commands() []Step {
+	if len(task.Steps) > 0 {
+		return task.Steps
+	}
+	if len(task.Cmd) > 0 {
+		return []Step{{Cmd: task.Cmd}}
+	}
+	return nil
+}
+
+// returns every string of the task that is a template: its commands, cwd and env values
+func (task Task) Templates() []string {
+	var templates []string
+	for _, step := range task.Commands() {
+		templates = append(templates, step.Cmd)
+	}
+	if len(task.Cwd) > 0 {
+		templates = append(templates, task.Cwd)
+	}
+	for _, value := range task.Env {
+		templates = append(templates, value)
+	}
+	return templates
+}
+
+// a task with only a command is written back as a plain string
+func (task Task) isPlain() bool {
+	return len(task.Steps) == 0 && task.hasOnlyCommands()
+}
+
+// a task with only steps is written back as a list
+func (task Task) isStepList() bool {
+	return len(task.Cmd) == 0 && len(task.Steps) > 0 && task.hasOnlyCommands()
+}
+
+func (task Task) hasOnlyCommands() bool {
+	return len(task.Desc) == 0 && len(task.Cwd) == 0 && len(task.Env) == 0 && len(task.Deps) == 0 &&
+		len(task.Parallel) == 0 && !task.FailFast && len(task.Exec) == 0
+}
+
+func (task *Task) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	*task = Task{}
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &task.Cmd)
+	}
+	if len(data) > 0 && data[0] == '[' {
+		return json.Unmarshal(data, &task.Steps)
+	}
+	if len(data) == 0 || data[0] != '{' {
+		return errTaskType
+	}
+
+	// alias so json.Unmarshal does not call this method again
+	type taskObject Task
+	var obj taskObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	if err := Task(obj).validate(); err != nil {
+		return err
+	}
+	*task = Task(obj)
+	return nil
+}
+
+var errTaskType = errors.New("a task must be a command string, a list of steps or an object")
+
+// checks the fields of a task written as an object
+func (task Task) validate() error {
+	if len(task.Cmd) > 0 && len(task.Steps) > 0 {
+		return errors.New("a task cannot have both \"cmd\" and \"steps\"")
+	}
+	if len(task.Parallel) > 0 && (len(task.Cmd) > 0 || len(task.Steps) > 0) {
+		return errors.New("a task cannot have \"parallel\" along with \"cmd\" or \"steps\"")
+	}
+	if len(task.Exec) > 0 && task.Exec != ExecShell && task.Exec != ExecDirect {
+		return fmt.Errorf("unknown exec mode '%s', expected \"%s\" or \"%s\"", task.Exec, ExecShell, ExecDirect)
+	}
+	return nil
+}
+
+func (task Task) MarshalJSON() ([]byte, error) {
+	if task.isPlain() {
+		return json.Marshal(task.Cmd)
+	}
+	if task.isStepList() {
+		return json.Marshal(task.Steps)
+	}
+	type taskObject Task
+	return json.Marshal(taskObject(task))
+}
+
+func (step *Step) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	*step = Step{}
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &step.Cmd)
+	}
+	if len(data) == 0 || data[0] != '{' {
+		return errStepType
+	}
+
+	type stepObject Step
+	var obj stepObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	*step = Step(obj)
+	return nil
+}
+
+func (step Step) MarshalJSON() ([]byte, error) {
+	if !step.ContinueOnError {
+		return json.Marshal(step.Cmd)
+	}
+	type stepObject Step
+	return json.Marshal(stepObject(step))
+}
+
+var errStepType = errors.New("a step must be a command string or an object")
+
+func (task *Task) UnmarshalYAML(node *yaml.Node) error {
+	*task = Task{}
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Decode(&task.Cmd)
+	case yaml.SequenceNode:
+		return node.Decode(&task.Steps)
+	case yaml.MappingNode:
+	default:
+		return yamlNodeError(node, errTaskType)
+	}
+
+	type taskObject Task
+	var obj taskObject
+	foldYAMLKeys(node, jsonFieldNames(reflect.TypeOf(obj)))
+	if err := node.Decode(&obj); err != nil {
+		return err
+	}
+	if err := Task(obj).validate(); err != nil {
+		return yamlNodeError(node, err)
+	}
+	*task = Task(obj)
+	return nil
+}
+
+func (task Task) MarshalYAML() (interface{}, error) {
+	if task.isPlain() {
+		return task.Cmd, nil
+	}
+	if task.isStepList() {
+		return task.Steps, nil
+	}
+	type taskObject Task
+	return taskObject(task), nil
+}
+
+func (step *Step) UnmarshalYAML(node *yaml.Node) error {
+	*step = Step{}
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Decode(&step.Cmd)
+	case yaml.MappingNode:
+	default:
+		return yamlNodeError(node, errStepType)
+	}
+
+	type stepObject Step
+	var obj stepObject
+	foldYAMLKeys(node, jsonFieldNames(reflect.TypeOf(obj)))
+	if err := node.Decode(&obj); err != nil {
+		return err
+	}
+	*step = Step(obj)
+	return nil
+}
+
+func (step Step) MarshalYAML() (interface{}, error) {
+	if !step.ContinueOnError {
+		return step.Cmd, nil
+	}
+	type stepObject Step
+	return stepObject(step), nil
+}
@@ -0,0 +1,140 @@
+package staskfile_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/itsfrank/stask/internal/staskfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTaskHappy(t *testing.T) {
+	var tests = []struct {
+		name  string
+		json  string
+		tasks map[string]staskfile.Task
+	}{
+		{
+			"string",
+			`{"Tasks": {"hello": "echo hello"}}`,
+			map[string]staskfile.Task{"hello": {Cmd: "echo hello"}},
+		},
+		{
+			"object",
+			`{"Tasks": {"build": {"cmd": "make", "desc": "build it", "cwd": "{repo}/sub", "env": {"CC": "{cc}"}}}}`,
+			map[string]staskfile.Task{"build": {Cmd: "make", Desc: "build it", Cwd: "{repo}/sub", Env: map[string]string{"CC": "{cc}"}}},
+		},
+		{
+			"list",
+			`{"Tasks": {"build": ["cmake -B build", {"cmd": "make clean", "continue_on_error": true}, {"cmd": "make"}]}}`,
+			map[string]staskfile.Task{"build": {Steps: []staskfile.Step{{Cmd: "cmake -B build"}, {Cmd: "make clean", ContinueOnError: true}, {Cmd: "make"}}}},
+		},
+		{
+			"steps",
+			`{"Tasks": {"build": {"steps": ["cmake -B build", "make"], "desc": "build it"}}}`,
+			map[string]staskfile.Task{"build": {Steps: []staskfile.Step{{Cmd: "cmake -B build"}, {Cmd: "make"}}, Desc: "build it"}},
+		},
+		{
+			"parallel",
+			`{"Tasks": {"dev": {"parallel": ["frontend", "backend"], "fail_fast": true}}}`,
+			map[string]staskfile.Task{"dev": {Parallel: []string{"frontend", "backend"}, FailFast: true}},
+		},
+		{
+			"exec",
+			`{"Tasks": {"build": {"cmd": "make", "exec": "direct"}}}`,
+			map[string]staskfile.Task{"build": {Cmd: "make", Exec: staskfile.ExecDirect}},
+		},
+		{
+			"mixed",
+			`{"Tasks": {"hello": "echo hello", "build": {"cmd": "make"}}}`,
+			map[string]staskfile.Task{"hello": {Cmd: "echo hello"}, "build": {Cmd: "make"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sf, err := staskfile.ParseStaskfile([]byte(tt.json))
+			assert.Nil(t, err)
+			assert.Equal(t, tt.tasks, sf.Tasks)
+		})
+	}
+}
+
+func TestParseTaskError(t *testing.T) {
+	var tests = []struct {
+		name string
+		json string
+		err  string
+	}{
+		{
+			"number",
+			`{"Tasks": {"hello": 42}}`,
+			"a task must be a command string, a list of steps or an object",
+		},
+		{
+			"bool",
+			`{"Tasks": {"hello": true}}`,
+			"a task must be a command string, a list of steps or an object",
+		},
+		{
+			"step",
+			`{"Tasks": {"hello": ["echo hello", 42]}}`,
+			"a step must be a command string or an object",
+		},
+		{
+			"cmd and steps",
+			`{"Tasks": {"hello": {"cmd": "echo hello", "steps": ["echo hi"]}}}`,
+			"a task cannot have both \"cmd\" and \"steps\"",
+		},
+		{
+			"exec",
+			`{"Tasks": {"build": {"cmd": "make", "exec": "bash"}}}`,
+			"unknown exec mode 'bash', expected \"shell\" or \"direct\"",
+		},
+		{
+			"cmd and parallel",
+			`{"Tasks": {"dev": {"cmd": "echo hello", "parallel": ["frontend"]}}}`,
+			"a task cannot have \"parallel\" along with \"cmd\" or \"steps\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the error is reported with the position of the task, see TestReadStaskfileParseError
+			_, err := staskfile.ParseStaskfile([]byte(tt.json))
+			assert.EqualError(t, errors.Unwrap(err), tt.err)
+		})
+	}
+}
+
+func TestSerializeTask(t *testing.T) {
+	sf := staskfile.Empty()
+	sf.Tasks["hello"] = staskfile.Task{Cmd: "echo hello"}
+	sf.Tasks["build"] = staskfile.Task{Cmd: "make", Desc: "build it"}
+	sf.Tasks["clean"] = staskfile.Task{Steps: []staskfile.Step{{Cmd: "make clean", ContinueOnError: true}, {Cmd: "rm -rf build"}}}
+	data, err := staskfile.SerializeStaskfile(sf)
+	assert.Nil(t, err)
+	assert.Equal(t, `{
+    "Version": 1,
+    "Tasks": {
+        "build": {
+            "cmd": "make",
+            "desc": "build it"
+        },
+        "clean": [
+            {
+                "cmd": "make clean",
+                "continue_on_error": true
+            },
+            "rm -rf build"
+        ],
+        "hello": "echo hello"
+    },
+    "State": {},
+    "Profiles": {}
+}`, string(data))
+}
+
+func TestTaskCommands(t *testing.T) {
+	assert.Equal(t, []staskfile.Step{{Cmd: "make"}}, staskfile.Task{Cmd: "make"}.Commands())
+	assert.Equal(t, []staskfile.Step{{Cmd: "a"}, {Cmd: "b"}}, staskfile.Task{Steps: []staskfile.Step{{Cmd: "a"}, {Cmd: "b"}}}.Commands())
+	assert.Equal(t, []staskfile.Step(nil), staskfile.Task{Deps: []string{"build"}}.Commands())
+}
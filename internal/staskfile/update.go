@@ -0,0 +1,304 @@
+package staskfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// a change to the state or profiles of a staskfile, applied to its document by UpdateStaskfile
+type edit struct {
+	// "State" or "Profiles"
+	section string
+	key     string
+	// the new value, a string for state and a map for profiles, nil to remove the key
+	value interface{}
+}
+
+// returns the changes to state and profiles that turn before into after, in a stable order
+// the second result is false when something else changed, the document is rewritten then
+func diffStaskfiles(before Staskfile, after Staskfile) ([]edit, bool) {
+	if !reflect.DeepEqual(before.Tasks, after.Tasks) || !reflect.DeepEqual(before.Include, after.Include) {
+		return nil, false
+	}
+
+	var edits []edit
+	for _, key := range sortedKeys(before.State) {
+		if _, found := after.State[key]; !found {
+			edits = append(edits, edit{"State", key, nil})
+		}
+	}
+	for _, key := range sortedKeys(after.State) {
+		if value, found := before.State[key]; !found || value != after.State[key] {
+			edits = append(edits, edit{"State", key, after.State[key]})
+		}
+	}
+	for _, name := range sortedKeys(before.Profiles) {
+		if _, found := after.Profiles[name]; !found {
+			edits = append(edits, edit{"Profiles", name, nil})
+		}
+	}
+	for _, name := range sortedKeys(after.Profiles) {
+		if profile, found := before.Profiles[name]; !found || !reflect.DeepEqual(profile, after.Profiles[name]) {
+			edits = append(edits, edit{"Profiles", name, after.Profiles[name]})
+		}
+	}
+	return edits, true
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writes the state and profiles of staskfile to the document at path, changing only the entries that differ
+// tasks, comments and the order of entries are kept as they were written
+// JSON and YAML staskfiles are edited in place, ErrNotEditable is returned when that is not possible rather than
+// losing what the document has that the staskfile does not, only TOML staskfiles and new ones are written whole
+// an older staskfile is upgraded to CurrentVersion along with the change, after making a backup of it
+func UpdateStaskfile(path string, staskfile Staskfile) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return WriteStaskfile(path, staskfile)
+	}
+	if err != nil {
+		return err
+	}
+	format := FormatOf(path)
+	before, version, err := parseStaskfileData(path, data)
+	if err != nil {
+		return err
+	}
+
+	edits, inPlace := diffStaskfiles(before, staskfile)
+	if !inPlace {
+		return fmt.Errorf("%w: '%s' has tasks or includes that changed, only state and profiles are updated", ErrNotEditable, path)
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+
+	original := data
+	if version < CurrentVersion && format != FormatTOML {
+		var ok bool
+		if data, ok = upgradeDocumentAs(data, format, version, before); !ok {
+			return fmt.Errorf("%w: '%s' cannot be upgraded to version %d in place, use \"stask upgrade\"", ErrNotEditable, path, CurrentVersion)
+		}
+	}
+	switch format {
+	case FormatJSON:
+		data, err = editJSON(data, edits)
+	case FormatYAML:
+		data, err = editYAML(data, edits)
+	}
+	if errors.Is(err, ErrNotEditable) {
+		return fmt.Errorf("%w: '%s' must be a block mapping whose \"State\" and \"Profiles\" are mappings", ErrNotEditable, path)
+	}
+	if err != nil {
+		return err
+	}
+
+	if version < CurrentVersion {
+		if err := backupStaskfile(path, original, version); err != nil {
+			return err
+		}
+	}
+	if format == FormatTOML {
+		// TOML documents cannot be edited through their values, they are written whole
+		return WriteStaskfile(path, staskfile)
+	}
+	return writeStaskfileData(path, data)
+}
+
+// returned when the document cannot be edited in place, it is left as it is
+var ErrNotEditable = errors.New("staskfile cannot be updated without rewriting it")
+
+func editJSON(data []byte, edits []edit) ([]byte, error) {
+	for _, edit := range edits {
+		root, err := readJSONObject(data, skipJSONSpace(data, 0))
+		if err != nil {
+			return nil, err
+		}
+		unit := jsonIndentOf(data, root)
+
+		section, found := root.find(edit.section, true)
+		if !found || bytes.HasPrefix(data[section.valueStart:], []byte("null")) {
+			if edit.value == nil {
+				continue
+			}
+			value, _ := json.MarshalIndent(map[string]interface{}{edit.key: edit.value}, "", unit)
+			if found {
+				data = setJSONMember(data, root, section.key, value, unit)
+			} else {
+				data = setJSONMember(data, root, edit.section, value, unit)
+			}
+			continue
+		}
+
+		object, err := readJSONObject(data, section.valueStart)
+		if err != nil {
+			return nil, err
+		}
+		if edit.value == nil {
+			data = deleteJSONMember(data, object, edit.key)
+			continue
+		}
+		value, err := json.MarshalIndent(edit.value, "", unit)
+		if err != nil {
+			return nil, err
+		}
+		data = setJSONMember(data, object, edit.key, value, unit)
+	}
+	return data, nil
+}
+
+// YAML staskfiles are edited through their nodes, which keep comments
+// only the lines of the edited sections are written again, the rest of the document is kept as it is
+func editYAML(data []byte, edits []edit) ([]byte, error) {
+	for _, edit := range edits {
+		root, ok := yamlRoot(data)
+		if !ok {
+			return nil, ErrNotEditable
+		}
+
+		index := -1
+		for i := 0; i < len(root.Content); i += 2 {
+			if root.Content[i].Value == edit.section {
+				index = i
+			}
+		}
+		if index < 0 {
+			if edit.value == nil {
+				continue
+			}
+			section := &yaml.Node{Kind: yaml.MappingNode}
+			if err := setYAMLKey(section, edit.key, edit.value); err != nil {
+				return nil, err
+			}
+			rendered, err := renderYAMLSection(&yaml.Node{Kind: yaml.ScalarNode, Value: edit.section}, section, yamlIndent(root))
+			if err != nil {
+				return nil, err
+			}
+			if len(data) > 0 && data[len(data)-1] != '\n' {
+				data = append(data, '\n')
+			}
+			data = append(data, rendered...)
+			continue
+		}
+
+		key, section := root.Content[index], root.Content[index+1]
+		if section.Kind == yaml.ScalarNode && section.Tag == "!!null" {
+			*section = yaml.Node{Kind: yaml.MappingNode, LineComment: section.LineComment}
+		}
+		if section.Kind != yaml.MappingNode {
+			return nil, ErrNotEditable
+		}
+		if edit.value == nil {
+			deleteYAMLKey(section, edit.key)
+		} else if err := setYAMLKey(section, edit.key, edit.value); err != nil {
+			return nil, err
+		}
+		// a flow mapping such as {} is written as a block once it has entries
+		if len(section.Content) > 0 {
+			section.Style &^= yaml.FlowStyle
+		}
+
+		rendered, err := renderYAMLSection(key, section, yamlIndent(root))
+		if err != nil {
+			return nil, err
+		}
+		lines := strings.SplitAfter(string(data), "\n")
+		start := key.Line - 1
+		end := len(lines)
+		if index+2 < len(root.Content) {
+			end = root.Content[index+2].Line - 1
+		}
+		// comments and blank lines before the next section belong to it
+		for end > start+1 {
+			line := lines[end-1]
+			if len(strings.TrimSpace(line)) > 0 && !strings.HasPrefix(line, "#") {
+				break
+			}
+			end--
+		}
+
+		var out strings.Builder
+		out.WriteString(strings.Join(lines[:start], ""))
+		out.WriteString(rendered)
+		out.WriteString(strings.Join(lines[end:], ""))
+		data = []byte(out.String())
+	}
+	return data, nil
+}
+
+func setYAMLKey(mapping *yaml.Node, key string, value interface{}) error {
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return err
+	}
+	for i := 0; i < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != key {
+			continue
+		}
+		old := mapping.Content[i+1]
+		// quoted values stay quoted, comments on the value are kept
+		if old.Kind == yaml.ScalarNode && node.Kind == yaml.ScalarNode && old.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle) != 0 {
+			node.Style = old.Style
+		}
+		node.LineComment = old.LineComment
+		node.FootComment = old.FootComment
+		mapping.Content[i+1] = &node
+		return nil
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &node)
+	return nil
+}
+
+func deleteYAMLKey(mapping *yaml.Node, key string) {
+	for i := 0; i < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// returns the indentation used by the nested mappings of the document, 4 spaces like serializeYAML when there are none
+func yamlIndent(root *yaml.Node) int {
+	for i := 1; i < len(root.Content); i += 2 {
+		value := root.Content[i]
+		if value.Kind == yaml.MappingNode && value.Style&yaml.FlowStyle == 0 && len(value.Content) > 0 {
+			return value.Content[0].Column - 1
+		}
+	}
+	return 4
+}
+
+// writes a single top-level key and its value, without the comments above the key that are kept in the document
+func renderYAMLSection(key *yaml.Node, value *yaml.Node, indent int) (string, error) {
+	keyCopy := *key
+	keyCopy.HeadComment = ""
+	mapping := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{&keyCopy, value}}
+
+	var builder strings.Builder
+	encoder := yaml.NewEncoder(&builder)
+	encoder.SetIndent(indent)
+	if err := encoder.Encode(mapping); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return builder.String(), nil
+}
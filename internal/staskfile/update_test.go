@@ -0,0 +1,188 @@
+package staskfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itsfrank/stask/internal/staskfile"
+	"github.com/stretchr/testify/assert"
+)
+
+const updateJSON = `{
+    "Version": 1,
+    // tasks in the order we want them
+    "Tasks": {
+        "zeta": "echo z",
+        "alpha": "echo a" // first task
+    },
+    "State": {
+        "flavor": "debug", // the build flavor
+        "arch": "x64"
+    },
+    "Profiles": {}
+}
+`
+
+const updateYAML = `Version: 1
+# tasks in the order we want them
+Tasks:
+  zeta: echo z
+  alpha: |
+    echo a
+    echo b
+
+# state
+State:
+  # the build flavor
+  flavor: debug # for now
+  arch: "x64"
+
+# saved profiles
+Profiles: {}
+`
+
+func updateFile(t *testing.T, name string, content string, change func(sf *staskfile.Staskfile)) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0644))
+
+	sf, err := staskfile.ReadStaskfileNoIncludes(path)
+	assert.Nil(t, err)
+	change(&sf)
+	assert.Nil(t, staskfile.UpdateStaskfile(path, sf))
+
+	updated, err := staskfile.ReadStaskfileNoIncludes(path)
+	assert.Nil(t, err)
+	assert.Equal(t, sf, updated)
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	return string(data)
+}
+
+func TestUpdateStaskfileJSON(t *testing.T) {
+	var tests = []struct {
+		name     string
+		change   func(sf *staskfile.Staskfile)
+		expected string
+	}{
+		{
+			"SetExisting",
+			func(sf *staskfile.Staskfile) { sf.State["flavor"] = "release" },
+			`"flavor": "release", // the build flavor`,
+		},
+		{
+			"SetNew",
+			func(sf *staskfile.Staskfile) { sf.State["cc"] = "clang" },
+			"        \"arch\": \"x64\",\n        \"cc\": \"clang\"\n    },",
+		},
+		{
+			"Clear",
+			func(sf *staskfile.Staskfile) { delete(sf.State, "flavor") },
+			"    \"State\": {\n        \"arch\": \"x64\"\n    },",
+		},
+		{
+			"ClearLast",
+			func(sf *staskfile.Staskfile) { delete(sf.State, "arch") },
+			"    \"State\": {\n        \"flavor\": \"debug\" // the build flavor\n    },",
+		},
+		{
+			"SaveProfile",
+			func(sf *staskfile.Staskfile) { sf.Profiles["release"] = map[string]string{"flavor": "release"} },
+			"    \"Profiles\": {\n        \"release\": {\n            \"flavor\": \"release\"\n        }\n    }\n}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := updateFile(t, "staskfile.json", updateJSON, tt.change)
+			assert.Contains(t, data, tt.expected)
+			assert.Contains(t, data, "    // tasks in the order we want them\n    \"Tasks\": {\n        \"zeta\": \"echo z\",\n        \"alpha\": \"echo a\" // first task\n    },")
+		})
+	}
+}
+
+func TestUpdateStaskfileJSONMissingSection(t *testing.T) {
+	data := updateFile(t, "staskfile.json", "{\"Version\": 1, \"Tasks\": {\"b\": \"x\", \"a\": \"y\"}}", func(sf *staskfile.Staskfile) {
+		sf.State["flavor"] = "debug"
+	})
+	assert.Equal(t, "{\"Version\": 1, \"Tasks\": {\"b\": \"x\", \"a\": \"y\"},\n\"State\": {\n    \"flavor\": \"debug\"\n}}", data)
+}
+
+func TestUpdateStaskfileYAML(t *testing.T) {
+	tasks := "# tasks in the order we want them\nTasks:\n  zeta: echo z\n  alpha: |\n    echo a\n    echo b\n\n# state\n"
+
+	var tests = []struct {
+		name     string
+		change   func(sf *staskfile.Staskfile)
+		expected string
+	}{
+		{
+			"SetExisting",
+			func(sf *staskfile.Staskfile) { sf.State["arch"] = "arm64" },
+			"State:\n  # the build flavor\n  flavor: debug # for now\n  arch: \"arm64\"\n\n# saved profiles\n",
+		},
+		{
+			"SetNew",
+			func(sf *staskfile.Staskfile) { sf.State["cc"] = "clang" },
+			"  arch: \"x64\"\n  cc: clang\n\n# saved profiles\n",
+		},
+		{
+			"Clear",
+			func(sf *staskfile.Staskfile) { delete(sf.State, "flavor") },
+			"State:\n  arch: \"x64\"\n\n# saved profiles\n",
+		},
+		{
+			"SaveProfile",
+			func(sf *staskfile.Staskfile) { sf.Profiles["release"] = map[string]string{"flavor": "release"} },
+			"# saved profiles\nProfiles:\n  release:\n    flavor: release\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := updateFile(t, "staskfile.yaml", updateYAML, tt.change)
+			assert.Contains(t, data, tt.expected)
+			assert.Contains(t, data, tasks)
+		})
+	}
+}
+
+func TestUpdateStaskfileRewrites(t *testing.T) {
+	// TOML staskfiles are written whole
+	data := updateFile(t, "staskfile.toml", "# comment\nVersion = 1\n[State]\nflavor = \"debug\"\n", func(sf *staskfile.Staskfile) {
+		sf.State["flavor"] = "release"
+	})
+	assert.Contains(t, data, "flavor = \"release\"")
+
+	path := filepath.Join(t.TempDir(), "new.json")
+	assert.Nil(t, staskfile.UpdateStaskfile(path, staskfile.Empty()))
+	sf, err := staskfile.ReadStaskfileNoIncludes(path)
+	assert.Nil(t, err)
+	assert.Equal(t, staskfile.Empty(), sf)
+}
+
+func TestUpdateStaskfileNotEditable(t *testing.T) {
+	var tests = []struct {
+		name    string
+		file    string
+		content string
+		change  func(sf *staskfile.Staskfile)
+	}{
+		{"Tasks", "staskfile.json", updateJSON, func(sf *staskfile.Staskfile) { sf.Tasks["beta"] = staskfile.Task{Cmd: "echo b"} }},
+		{"YAMLFlow", "staskfile.yaml", "{Version: 1, State: {flavor: debug}} # flow\n", func(sf *staskfile.Staskfile) { sf.State["flavor"] = "release" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the document is left as it is rather than written without its comments
+			path := filepath.Join(t.TempDir(), tt.file)
+			assert.Nil(t, os.WriteFile(path, []byte(tt.content), 0644))
+			sf, err := staskfile.ReadStaskfileNoIncludes(path)
+			assert.Nil(t, err)
+			tt.change(&sf)
+
+			assert.ErrorIs(t, staskfile.UpdateStaskfile(path, sf), staskfile.ErrNotEditable)
+			data, err := os.ReadFile(path)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.content, string(data))
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package staskfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/itsfrank/stask/internal/template"
+)
+
+// a problem found in a staskfile by Validate
+type Problem struct {
+	// the position of the value the problem is about, 0 when it is not known
+	Line    int
+	Column  int
+	Message string
+}
+
+func (problem Problem) String() string {
+	if problem.Line == 0 {
+		return problem.Message
+	}
+	if problem.Column == 0 {
+		return fmt.Sprintf("line %d: %s", problem.Line, problem.Message)
+	}
+	return fmt.Sprintf("line %d, character %d: %s", problem.Line, problem.Column, problem.Message)
+}
+
+// key of the JSON Schema of a staskfile, editors use it to find the schema, see Schema
+const SchemaKey = "$schema"
+
+// checks the staskfile at path and returns every problem found, ordered by position:
+// values of the wrong type, unknown keys, tasks that are not valid templates, dependencies on tasks that do not exist,
+// and profiles setting keys that no task uses
+// the error is only set when the staskfile cannot be read
+func Validate(path string) ([]Problem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format := FormatOf(path)
+	// nothing can be checked in a staskfile that cannot be read, the parser of the format says where it stops
+	if _, err := decodeDocument(data, format); err != nil {
+		return []Problem{{Message: formatError(data, format, err).Error()}}, nil
+	}
+	doc, err := parseDocument(data, format)
+	if err != nil {
+		return []Problem{{Message: err.Error()}}, nil
+	}
+
+	v := validator{path: path, usedKeys: map[string]bool{}, tasks: map[string]bool{}}
+	v.validate(doc)
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		if v.problems[i].Line != v.problems[j].Line {
+			return v.problems[i].Line < v.problems[j].Line
+		}
+		return v.problems[i].Column < v.problems[j].Column
+	})
+	return v.problems, nil
+}
+
+type validator struct {
+	path     string
+	problems []Problem
+	// keys inserted or tested by tasks and state values
+	usedKeys map[string]bool
+	// names of the tasks, including included ones
+	tasks map[string]bool
+}
+
+func (v *validator) addProblem(line int, column int, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{line, column, fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) validate(doc *docNode) {
+	if doc.Kind != nodeObject {
+		v.addProblem(doc.Line, doc.Column, "a staskfile must be an object")
+		return
+	}
+
+	version, err := documentVersion(doc.value().(map[string]interface{}))
+	if err != nil {
+		v.addProblem(doc.Line, doc.Column, "%v", err)
+	} else if version > CurrentVersion {
+		v.addProblem(doc.Line, doc.Column, "%v", &VersionError{version})
+		return
+	}
+
+	fields := map[string]docMember{}
+	for _, member := range doc.Members {
+		field := member.Key
+		// older staskfiles matched their fields without case, they are renamed when upgraded
+		for _, name := range fieldNames {
+			if version < CurrentVersion && strings.EqualFold(member.Key, name) {
+				field = name
+			}
+		}
+		switch field {
+		case SchemaKey:
+			if _, ok := member.Value.Value.(string); !ok {
+				v.addProblem(member.Line, member.Column, "\"%s\" must be a string", SchemaKey)
+			}
+		case "Version", "Include", "Tasks", "State", "Profiles":
+			fields[field] = member
+		default:
+			v.addProblem(member.Line, member.Column, "unknown key '%s'%s", member.Key, didYouMean(member.Key, fieldNames))
+		}
+	}
+
+	if member, found := fields["Tasks"]; found && v.isObject(member, "\"Tasks\"") {
+		for _, task := range member.Value.Members {
+			v.tasks[task.Key] = true
+		}
+	}
+	if member, found := fields["Include"]; found {
+		v.validateIncludes(member)
+	}
+	if member, found := fields["Tasks"]; found && member.Value.Kind == nodeObject {
+		for _, task := range member.Value.Members {
+			v.validateTask(task)
+		}
+	}
+	if member, found := fields["State"]; found && v.isObject(member, "\"State\"") {
+		for _, value := range member.Value.Members {
+			str, ok := value.Value.Value.(string)
+			if !ok {
+				v.addProblem(value.Line, value.Column, "state value '%s' must be a string", value.Key)
+				continue
+			}
+			v.useTemplate(value.Value, str, fmt.Sprintf("state value '%s'", value.Key))
+		}
+	}
+	if member, found := fields["Profiles"]; found && v.isObject(member, "\"Profiles\"") {
+		for _, profile := range member.Value.Members {
+			v.validateProfile(profile)
+		}
+	}
+}
+
+func (v *validator) isObject(member docMember, what string) bool {
+	if member.Value.Kind != nodeObject {
+		v.addProblem(member.Line, member.Column, "%s must be an object", what)
+		return false
+	}
+	return true
+}
+
+// includes are read to know the tasks they add, problems inside them are reported by validating them
+func (v *validator) validateIncludes(member docMember) {
+	if member.Value.Kind != nodeArray {
+		v.addProblem(member.Line, member.Column, "\"Include\" must be a list")
+		return
+	}
+	path, err := filepath.Abs(v.path)
+	if err != nil {
+		return
+	}
+
+	for _, item := range member.Value.Items {
+		var include Include
+		if err := item.decode(&include); err != nil {
+			v.addProblem(item.Line, item.Column, "%v", err)
+			continue
+		}
+		v.checkKeys(item, include, "include")
+		if len(include.Path) == 0 {
+			v.addProblem(item.Line, item.Column, "an include must have a path")
+			continue
+		}
+		includedPath, err := resolveIncludePath(filepath.Dir(path), include.Path)
+		if err != nil {
+			v.addProblem(item.Line, item.Column, "%v", err)
+			continue
+		}
+		included, err := readIncluding(includedPath, []string{path})
+		if err != nil {
+			v.addProblem(item.Line, item.Column, "included staskfile '%s': %v", include.Path, err)
+			continue
+		}
+		for name, task := range included.Tasks {
+			v.tasks[include.Namespace()+NamespaceSeparator+name] = true
+			for _, str := range task.Templates() {
+				// problems in the templates of included tasks are reported by validating their staskfile
+				if tmpl, err := template.ParseTemplate(str); err == nil {
+					v.useKeys(tmpl)
+				}
+			}
+		}
+		for _, value := range included.State {
+			if tmpl, err := template.ParseTemplate(value); err == nil {
+				v.useKeys(tmpl)
+			}
+		}
+	}
+}
+
+func (v *validator) validateTask(member docMember) {
+	task, at, err := decodeTask(member.Value)
+	if err != nil {
+		v.addProblem(at.Line, at.Column, "task '%s': %v", member.Key, err)
+		return
+	}
+
+	node := member.Value
+	what := fmt.Sprintf("task '%s'", member.Key)
+	switch node.Kind {
+	case nodeScalar:
+		v.useTemplate(node, task.Cmd, what)
+	case nodeArray:
+		v.validateSteps(node, what)
+	case nodeObject:
+		v.checkKeys(node, task, what)
+		for _, field := range node.Members {
+			switch field.Key {
+			case "cmd", "cwd":
+				v.useTemplate(field.Value, field.Value.str(), what)
+			case "steps":
+				v.validateSteps(field.Value, what)
+			case "env":
+				for _, env := range field.Value.Members {
+					v.useTemplate(env.Value, env.Value.str(), fmt.Sprintf("%s, env '%s'", what, env.Key))
+				}
+			case "deps", "parallel":
+				kind := "dependency"
+				if field.Key == "parallel" {
+					kind = "parallel task"
+				}
+				for _, item := range field.Value.Items {
+					if name := item.str(); !v.tasks[name] {
+						v.addProblem(item.Line, item.Column, "%s: %s '%s' was not found in staskfile", what, kind, name)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (v *validator) validateSteps(node *docNode, what string) {
+	for _, item := range node.Items {
+		if item.Kind == nodeScalar {
+			v.useTemplate(item, item.str(), what)
+			continue
+		}
+		var step Step
+		item.decode(&step)
+		v.checkKeys(item, step, what+" step")
+		for _, field := range item.Members {
+			if field.Key == "cmd" {
+				v.useTemplate(field.Value, step.Cmd, what)
+			}
+		}
+	}
+}
+
+func (v *validator) validateProfile(member docMember) {
+	if !v.isObject(member, fmt.Sprintf("profile '%s'", member.Key)) {
+		return
+	}
+	for _, value := range member.Value.Members {
+		if _, ok := value.Value.Value.(string); !ok {
+			v.addProblem(value.Line, value.Column, "profile '%s': value of '%s' must be a string", member.Key, value.Key)
+		}
+		if !v.usedKeys[value.Key] {
+			v.addProblem(value.Line, value.Column, "profile '%s' sets '%s', which no task uses", member.Key, value.Key)
+		}
+	}
+}
+
+// parses a template of the staskfile, reporting it if it is not valid, and remembers the keys it uses
+func (v *validator) useTemplate(node *docNode, str string, what string) {
+	tmpl, err := template.ParseTemplate(str)
+	v.useParsedTemplate(node, tmpl, err, what)
+}
+
+// errors of templates are reported at the position of the brace they are about, or of the value when the
+// runes of the value cannot be found in the source
+func (v *validator) useParsedTemplate(node *docNode, tmpl template.Template, err error, what string) {
+	if err != nil {
+		var parseErr *template.ParseError
+		if errors.As(err, &parseErr) && node.Line > 0 {
+			line, column, _ := node.runePosition(parseErr.Offset)
+			v.addProblem(line, column, "%s: %v", what, parseErr.Err)
+			return
+		}
+		v.addProblem(node.Line, node.Column, "%s: %v", what, err)
+		return
+	}
+	v.useKeys(tmpl)
+}
+
+func (v *validator) useKeys(tmpl template.Template) {
+	for _, key := range append(tmpl.Keys, tmpl.CondKeys...) {
+		if len(key.Namespace) == 0 {
+			v.usedKeys[key.Str] = true
+		}
+	}
+}
+
+// reports the keys of an object that are not fields of value, whose fields are named by their json tags
+func (v *validator) checkKeys(node *docNode, value interface{}, what string) {
+	if node.Kind != nodeObject {
+		return
+	}
+	names := jsonFieldNames(reflect.TypeOf(value))
+	for _, member := range node.Members {
+		found := false
+		for _, name := range names {
+			found = found || name == member.Key
+		}
+		if !found {
+			v.addProblem(member.Line, member.Column, "%s: unknown key '%s'%s", what, member.Key, didYouMean(member.Key, names))
+		}
+	}
+}
+
+// returns the names of the fields of a struct as encoding/json writes them
+func jsonFieldNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// suggests the known key that only differs from key by its case, encoding/json would have accepted it
+func didYouMean(key string, names []string) string {
+	for _, name := range names {
+		if strings.EqualFold(key, name) {
+			return fmt.Sprintf(", did you mean '%s'?", name)
+		}
+	}
+	return ""
+}
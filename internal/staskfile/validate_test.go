@@ -0,0 +1,167 @@
+package staskfile_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itsfrank/stask/internal/staskfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func validateFile(t *testing.T, name string, content string) []string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0644))
+
+	problems, err := staskfile.Validate(path)
+	assert.Nil(t, err)
+	var found []string
+	for _, problem := range problems {
+		found = append(found, problem.String())
+	}
+	return found
+}
+
+func TestValidateJSON(t *testing.T) {
+	problems := validateFile(t, "staskfile.json", `{
+    "Version": 1,
+    "$schema": "./staskfile.schema.json",
+    "Taks": {},
+    "Tasks": {
+        // comments are not problems
+        "build": {"cmd": "make {target", "Cwd": "src", "deps": ["gen"]},
+        "test": ["go test {pkg}", {"cmd": "echo done", "continue": true}]
+    },
+    "State": {"pkg": "./...", "dir": "{missing", "awk": "{{print $1}}"},
+    "Profiles": {"ci": {"pkg": "./cmd/...", "verbose": "1"}}
+}
+`)
+	assert.Equal(t, []string{
+		"line 4, character 5: unknown key 'Taks'",
+		"line 7, character 32: task 'build': Found opening '{' without closing '}'",
+		"line 7, character 42: task 'build': unknown key 'Cwd', did you mean 'cwd'?",
+		"line 7, character 65: task 'build': dependency 'gen' was not found in staskfile",
+		"line 8, character 56: task 'test' step: unknown key 'continue'",
+		"line 10, character 39: state value 'dir': Found opening '{' without closing '}'",
+		"line 11, character 45: profile 'ci' sets 'verbose', which no task uses",
+	}, problems)
+}
+
+func TestValidateYAML(t *testing.T) {
+	problems := validateFile(t, "staskfile.yaml", `Version: 1
+Tasks:
+  build:
+    cwd: "{target}"
+    parallel: [lint, vet]
+  lint: {command: golint}
+State:
+  target: all
+Profiles:
+  release:
+    target: install
+    flavor: release
+`)
+	assert.Equal(t, []string{
+		"line 5, character 22: task 'build': parallel task 'vet' was not found in staskfile",
+		"line 6, character 10: task 'lint': unknown key 'command'",
+		"line 12, character 5: profile 'release' sets 'flavor', which no task uses",
+	}, problems)
+}
+
+func TestValidateTemplatePositions(t *testing.T) {
+	// template errors are reported at the brace they are about, after escapes and in blocks
+	problems := validateFile(t, "staskfile.json", `{"Version": 1, "Tasks": {
+    "a": "echo \"\u00e9\ud83d\ude00\" {x"
+}}`)
+	assert.Equal(t, []string{"line 2, character 39: task 'a': Found opening '{' without closing '}'"}, problems)
+
+	problems = validateFile(t, "staskfile.yaml", `Version: 1
+Tasks:
+  plain: echo {x
+  double: "echo \t{x"
+  single: 'it''s {x'
+  block: |
+    echo one
+      echo {x
+`)
+	assert.Equal(t, []string{
+		"line 3, character 15: task 'plain': Found opening '{' without closing '}'",
+		"line 4, character 19: task 'double': Found opening '{' without closing '}'",
+		"line 5, character 18: task 'single': Found opening '{' without closing '}'",
+		"line 8, character 12: task 'block': Found opening '{' without closing '}'",
+	}, problems)
+}
+
+func TestValidateTaskTypes(t *testing.T) {
+	problems := validateFile(t, "staskfile.json", `{"Version": 1, "Tasks": {
+    "a": "echo a",
+    "b": {"cmd": "echo b", "env": {"A": 1}},
+    "c": {"deps": ["a", true]},
+    "d": 3
+}}`)
+	assert.Equal(t, []string{
+		"line 3, character 41: task 'b': env 'A' must be a string",
+		"line 4, character 25: task 'c': deps item 2 must be a string",
+		"line 5, character 10: task 'd': a task must be a command string, a list of steps or an object",
+	}, problems)
+
+	problems = validateFile(t, "staskfile.yaml", `Version: 1
+Tasks:
+  b:
+    cmd: echo b
+    env: {A: [a]}
+`)
+	assert.Equal(t, []string{"line 5, character 14: task 'b': env 'A' must be a string"}, problems)
+}
+
+func TestValidateValid(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "tools.json"), []byte(`{"Tasks": {"fmt": "gofmt -w {dir}"}}`), 0644))
+
+	path := filepath.Join(dir, "staskfile.toml")
+	assert.Nil(t, os.WriteFile(path, []byte(`Version = 1
+Include = ["tools.json"]
+
+[Tasks]
+build = "go build{if race} -race{end} ./..."
+
+[Tasks.all]
+deps = ["tools:fmt", "build"]
+
+[Profiles.ci]
+race = "1"
+dir = "."
+`), 0644))
+
+	problems, err := staskfile.Validate(path)
+	assert.Nil(t, err)
+	assert.Empty(t, problems)
+}
+
+func TestValidateSyntaxError(t *testing.T) {
+	problems := validateFile(t, "staskfile.json", "{\n    \"Tasks\": {\n        \"build\": \"make\",\n    }\n}\n")
+	assert.Len(t, problems, 1)
+	assert.Contains(t, problems[0], "line 5, character 1")
+}
+
+func TestSchema(t *testing.T) {
+	data, err := staskfile.Schema()
+	assert.Nil(t, err)
+
+	var schema map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &schema))
+	assert.Equal(t, "object", schema["type"])
+	assert.Equal(t, false, schema["additionalProperties"])
+
+	properties := schema["properties"].(map[string]interface{})
+	for _, key := range []string{"$schema", "Version", "Include", "Tasks", "State", "Profiles"} {
+		assert.Contains(t, properties, key)
+	}
+	assert.Equal(t, map[string]interface{}{"$ref": "#/$defs/Task"}, properties["Tasks"].(map[string]interface{})["additionalProperties"])
+
+	defs := schema["$defs"].(map[string]interface{})
+	for _, key := range []string{"Task", "Step", "Include"} {
+		assert.Contains(t, defs, key)
+	}
+}
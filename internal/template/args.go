@@ -0,0 +1,68 @@
+package template
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/google/shlex"
+)
+
+// placeholders are delimited by unicode private use characters, they cannot be typed by accident
+const (
+	placeholderStart = '\uE000'
+	placeholderEnd   = '\uE001'
+)
+
+type placeholderValue struct {
+	val string
+	// {args} is spliced into as many arguments as were forwarded when it is an argument on its own
+	splice bool
+}
+
+func placeholder(index int) string {
+	return string(placeholderStart) + strconv.Itoa(index) + string(placeholderEnd)
+}
+
+// like ApplyTemplate, but returns the result split into arguments the way a shell would split it
+// the quoting in the template decides where arguments start and end, inserted values are never split:
+// a value with spaces or quotes stays part of the argument it was inserted in
+func ApplyTemplateArgs(tmpl Template, values map[string]string) ([]string, []string, error) {
+	var placeholders []placeholderValue
+	r := newResolver(values)
+	r.placeholders = &placeholders
+	str, missing, err := r.apply(tmpl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	args, err := shlex.Split(str)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	replacements := make([]string, 0, len(placeholders)*2)
+	for i, placeholderValue := range placeholders {
+		replacements = append(replacements, placeholder(i), placeholderValue.val)
+	}
+	replacer := strings.NewReplacer(replacements...)
+
+	var replaced []string
+	for _, arg := range args {
+		spliced := false
+		for i, placeholderValue := range placeholders {
+			if arg == placeholder(i) && placeholderValue.splice {
+				spliceArgs, err := shlex.Split(placeholderValue.val)
+				if err != nil {
+					return nil, nil, err
+				}
+				replaced = append(replaced, spliceArgs...)
+				spliced = true
+				break
+			}
+		}
+		if !spliced {
+			replaced = append(replaced, replacer.Replace(arg))
+		}
+	}
+	return replaced, missing, nil
+}
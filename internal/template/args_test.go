@@ -0,0 +1,104 @@
+package template_test
+
+import (
+	"testing"
+
+	"github.com/itsfrank/stask/internal/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyTemplateArgs(t *testing.T) {
+	var tests = []struct {
+		str    string
+		values map[string]string
+		args   []string
+	}{
+		{
+			"grep -r {pattern} {dir}",
+			map[string]string{"pattern": "two words", "dir": "src"},
+			[]string{"grep", "-r", "two words", "src"},
+		},
+		{
+			"cc --out={out}/bin \"{name} v2\" 'x y'",
+			map[string]string{"out": "my build", "name": "it's \"quoted\""},
+			[]string{"cc", "--out=my build/bin", "it's \"quoted\" v2", "x y"},
+		},
+		{
+			"echo {empty} $HOME {dollar}",
+			map[string]string{"empty": "", "dollar": "$HOME"},
+			[]string{"echo", "", "$HOME", "$HOME"},
+		},
+		{
+			"make{if opt} -O{opt}{end} {target}",
+			map[string]string{"opt": "2", "target": "all of it"},
+			[]string{"make", "-O2", "all of it"},
+		},
+		{
+			"ls {out_dir}",
+			map[string]string{"out_dir": "build/{flavor}", "flavor": "debug x64"},
+			[]string{"ls", "build/debug x64"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			tmpl, err := template.ParseTemplate(tt.str)
+			assert.Nil(t, err)
+			args, mss, err := template.ApplyTemplateArgs(tmpl, tt.values)
+			assert.Nil(t, err)
+			assert.Equal(t, []string(nil), mss)
+			assert.Equal(t, tt.args, args)
+		})
+	}
+}
+
+func TestApplyTemplateArgsForwarded(t *testing.T) {
+	var tests = []struct {
+		str  string
+		fwd  []string
+		args []string
+	}{
+		{
+			"grep {args} src",
+			[]string{"-i", "two words", "it's"},
+			[]string{"grep", "-i", "two words", "it's", "src"},
+		},
+		{
+			"grep {args} src ''",
+			nil,
+			[]string{"grep", "src", ""},
+		},
+		{
+			"grep -e {args[1]} {args[0]}",
+			[]string{"src", "two words"},
+			[]string{"grep", "-e", "two words", "src"},
+		},
+		{
+			"echo --all={args}",
+			[]string{"a b", "c"},
+			[]string{"echo", "--all='a b' c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			tmpl, err := template.ParseTemplate(tt.str)
+			assert.Nil(t, err)
+			args, mss, err := template.ApplyTemplateArgs(tmpl, template.WithArgs(map[string]string{}, tt.fwd))
+			assert.Nil(t, err)
+			assert.Equal(t, []string(nil), mss)
+			assert.Equal(t, tt.args, args)
+		})
+	}
+}
+
+func TestApplyTemplateArgsError(t *testing.T) {
+	tmpl, err := template.ParseTemplate("echo \"{msg}")
+	assert.Nil(t, err)
+	_, _, err = template.ApplyTemplateArgs(tmpl, map[string]string{"msg": "hi"})
+	assert.NotNil(t, err)
+
+	tmpl, err = template.ParseTemplate("echo {msg} {other}")
+	assert.Nil(t, err)
+	_, mss, err := template.ApplyTemplateArgs(tmpl, map[string]string{"msg": "hi"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"other"}, mss)
+}
@@ -0,0 +1,42 @@
+package template
+
+// a parsed piece of a template, one of Text, Key or Cond
+type Node interface {
+	node()
+}
+
+// literal text, with escaped braces already unescaped
+type Text string
+
+// a section only included when its condition holds:
+//
+//	{if key}...{end}          key is set and not empty
+//	{if key=value}...{end}    key is set to value
+//	{if key!=value}...{end}   key is not set to value
+//
+// an optional {else} splits the section in two
+// the key can have filters, the value follows them, e.g. {if branch|lower=main}
+type Cond struct {
+	Key Key
+	// one of "", "=" or "!="
+	Op    string
+	Value string
+	Then  []Node
+	Else  []Node
+}
+
+func (Text) node() {}
+func (Key) node()  {}
+func (Cond) node() {}
+
+// whether the condition holds for the (filtered) value of its key
+func (cond Cond) eval(val string, prs bool) bool {
+	switch cond.Op {
+	case "=":
+		return prs && val == cond.Value
+	case "!=":
+		return !prs || val != cond.Value
+	default:
+		return prs && len(val) > 0
+	}
+}
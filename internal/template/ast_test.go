@@ -0,0 +1,188 @@
+package template_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/itsfrank/stask/internal/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCondHappy(t *testing.T) {
+	tmpl, err := template.ParseTemplate("cc{if asan=on} -fsanitize=address{end}{if opt} -O{opt}{else} -O0{end} {src}")
+	assert.Nil(t, err)
+	assert.Equal(t, "cc -fsanitize=address -O -O0 ", tmpl.Str)
+	assert.Equal(t, []template.Key{{Index: 24, Str: "opt"}, {Index: 29, Str: "src"}}, tmpl.Keys)
+	assert.Equal(t, []template.Key{{Index: 2, Str: "asan"}, {Index: 21, Str: "opt"}}, tmpl.CondKeys)
+	assert.Equal(t, []template.Node{
+		template.Text("cc"),
+		template.Cond{
+			Key:   template.Key{Index: 2, Str: "asan"},
+			Op:    "=",
+			Value: "on",
+			Then:  []template.Node{template.Text(" -fsanitize=address")},
+		},
+		template.Cond{
+			Key:  template.Key{Index: 21, Str: "opt"},
+			Then: []template.Node{template.Text(" -O"), template.Key{Index: 24, Str: "opt"}},
+			Else: []template.Node{template.Text(" -O0")},
+		},
+		template.Text(" "),
+		template.Key{Index: 29, Str: "src"},
+	}, tmpl.Nodes)
+}
+
+func TestParseCondError(t *testing.T) {
+	var tests = []struct {
+		str    string
+		offset int
+		err    error
+	}{
+		{
+			"cc {if asan} -fsanitize=address",
+			3,
+			errors.New("Found {if} without closing {end}"),
+		},
+		{
+			"cc -fsanitize=address{end}",
+			21,
+			errors.New("Found {end} without {if}"),
+		},
+		{
+			"cc {else} -O0",
+			3,
+			errors.New("Found {else} outside of {if}"),
+		},
+		{
+			"cc {if opt}-O2{else}-O1{else}-O0{end}",
+			23,
+			errors.New("Found second {else} in {if}"),
+		},
+		{
+			"cc {if =on} -fsanitize=address{end}",
+			3,
+			errors.New("Empty Key"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			_, err := template.ParseTemplate(tt.str)
+			assert.Equal(t, &template.ParseError{Template: tt.str, Offset: tt.offset, Err: tt.err}, err)
+		})
+	}
+}
+
+func TestApplyCond(t *testing.T) {
+	const build = "cc{if asan=on} --sanitize=address{end}{if opt} -O{opt}{else} -O0{end}{if env:STASK_TEST_CI} --ci{end} {src}"
+	t.Setenv("STASK_TEST_CI", "1")
+
+	var tests = []struct {
+		str     string
+		values  map[string]string
+		result  string
+		missing []string
+	}{
+		{
+			build,
+			map[string]string{"src": "a.c"},
+			"cc -O0 --ci a.c",
+			nil,
+		},
+		{
+			build,
+			map[string]string{"src": "a.c", "asan": "on", "opt": "2"},
+			"cc --sanitize=address -O2 --ci a.c",
+			nil,
+		},
+		{
+			build,
+			map[string]string{"src": "a.c", "asan": "off", "opt": ""},
+			"cc -O0 --ci a.c",
+			nil,
+		},
+		{
+			"make{if config!=debug} NDEBUG=1{end}",
+			map[string]string{"config": "release"},
+			"make NDEBUG=1",
+			nil,
+		},
+		{
+			"make{if config!=debug} NDEBUG=1{end}",
+			map[string]string{},
+			"make NDEBUG=1",
+			nil,
+		},
+		{
+			"make{if config|lower=debug} DEBUG=1{end}",
+			map[string]string{"config": "Debug"},
+			"make DEBUG=1",
+			nil,
+		},
+		{
+			"make{if flags|replace:-O=:-O=-O2} OPT=1{end}",
+			map[string]string{"flags": "-O=2"},
+			"make OPT=1",
+			nil,
+		},
+		{
+			"make{if mode|upper!=A|B} MODE={mode}{end}",
+			map[string]string{"mode": "a|b"},
+			"make",
+			nil,
+		},
+		{
+			"make{if flags|replace:=:-} FLAGS={flags}{end}",
+			map[string]string{"flags": "a=b"},
+			"make FLAGS=a=b",
+			nil,
+		},
+		{
+			"echo {end|raw} {else|raw}",
+			map[string]string{"end": "e", "else": "x"},
+			"echo e x",
+			nil,
+		},
+		{
+			"make{if config:-debug=debug} DEBUG=1{end}",
+			map[string]string{},
+			"make DEBUG=1",
+			nil,
+		},
+		{
+			"make{if config}{if jobs} -j{jobs}{end}{end}",
+			map[string]string{"config": "debug", "jobs": "4"},
+			"make -j4",
+			nil,
+		},
+		{
+			"make{if config} CONFIG={config}{else} {target}{end}",
+			map[string]string{"config": "debug"},
+			"make CONFIG=debug",
+			nil,
+		},
+		{
+			"make{if config} CONFIG={config}{else} {target}{end}",
+			map[string]string{},
+			"make",
+			[]string{"target"},
+		},
+		{
+			"make{if out} -C {out}{end}",
+			map[string]string{"out": "build/{flavor}"},
+			"make -C build/",
+			[]string{"flavor"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			tmpl, err := template.ParseTemplate(tt.str)
+			assert.Nil(t, err)
+			res, mss, err := template.ApplyTemplate(tmpl, tt.values)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.missing, mss)
+			if len(tt.missing) == 0 {
+				assert.Equal(t, tt.result, res)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// separates a filter's name from its arguments, and the arguments from each other, e.g. {name|replace:/:-}
+const filterArgSeparator = ":"
+
+type Filter struct {
+	Name string
+	Args []string
+}
+
+type filterDef struct {
+	nargs int
+	apply func(val string, args []string) string
+}
+
+var filters = map[string]filterDef{}
+
+// adds a filter to the registry, nargs is the exact number of arguments the filter expects
+func registerFilter(name string, nargs int, apply func(val string, args []string) string) {
+	filters[name] = filterDef{nargs, apply}
+}
+
+func init() {
+	// quoting
+	registerFilter("q", 0, func(val string, _ []string) string { return quoteSingle(val) })
+	registerFilter("dq", 0, func(val string, _ []string) string { return quoteDouble(val) })
+	registerFilter("raw", 0, func(val string, _ []string) string { return val })
+
+	// strings
+	registerFilter("lower", 0, func(val string, _ []string) string { return strings.ToLower(val) })
+	registerFilter("upper", 0, func(val string, _ []string) string { return strings.ToUpper(val) })
+	registerFilter("trim", 0, func(val string, _ []string) string { return strings.TrimSpace(val) })
+	registerFilter("replace", 2, func(val string, args []string) string { return strings.ReplaceAll(val, args[0], args[1]) })
+
+	// paths
+	registerFilter("basename", 0, func(val string, _ []string) string { return filepath.Base(val) })
+	registerFilter("dirname", 0, func(val string, _ []string) string { return filepath.Dir(val) })
+	registerFilter("abs", 0, func(val string, _ []string) string {
+		abs, err := filepath.Abs(val)
+		if err != nil {
+			return filepath.Clean(val)
+		}
+		return abs
+	})
+}
+
+func parseFilter(str string) (Filter, error) {
+	parts := strings.Split(str, filterArgSeparator)
+	filter := Filter{Name: strings.TrimSpace(parts[0])}
+	if len(filter.Name) == 0 {
+		return Filter{}, errors.New("Empty filter")
+	}
+
+	def, found := filters[filter.Name]
+	if !found {
+		return Filter{}, fmt.Errorf("Unknown filter '%s'", filter.Name)
+	}
+
+	if len(parts) > 1 {
+		filter.Args = parts[1:]
+	}
+	if len(filter.Args) != def.nargs {
+		return Filter{}, fmt.Errorf("Filter '%s' expects %d arguments, got %d", filter.Name, def.nargs, len(filter.Args))
+	}
+	return filter, nil
+}
+
+// runs the filters in order, each one receiving the output of the previous one
+func applyFilters(keyFilters []Filter, val string) string {
+	for _, filter := range keyFilters {
+		val = filters[filter.Name].apply(val, filter.Args)
+	}
+	return val
+}
+
+// quotes the value for a POSIX shell only if it needs it, to display commands that are not run by a shell
+func ShellQuote(val string) string {
+	safe := func(chr rune) bool {
+		return chr < 128 && (chr >= 'a' && chr <= 'z' || chr >= 'A' && chr <= 'Z' || chr >= '0' && chr <= '9' ||
+			strings.ContainsRune("-_./=:,+@%", chr))
+	}
+	for _, chr := range val {
+		if !safe(chr) {
+			return quoteSingle(val)
+		}
+	}
+	if len(val) == 0 {
+		return quoteSingle(val)
+	}
+	return val
+}
+
+// wraps the value in single quotes, safe for any POSIX shell
+// a single quote inside the value ends the quoted string, is escaped, and starts a new one
+func quoteSingle(val string) string {
+	return "'" + strings.ReplaceAll(val, "'", `'\''`) + "'"
+}
+
+// wraps the value in double quotes, escaping the characters the shell
+// still interprets inside of them
+func quoteDouble(val string) string {
+	var builder strings.Builder
+	builder.Grow(len(val) + 2)
+	builder.WriteByte('"')
+	for _, chr := range val {
+		switch chr {
+		case '"', '\\', '$', '`':
+			builder.WriteByte('\\')
+		}
+		builder.WriteRune(chr)
+	}
+	builder.WriteByte('"')
+	return builder.String()
+}
@@ -0,0 +1,156 @@
+package template_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/itsfrank/stask/internal/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFilterHappy(t *testing.T) {
+	var tests = []struct {
+		str  string
+		keys []template.Key
+	}{
+		{
+			"ls {path|q}",
+			[]template.Key{{Index: 3, Str: "path", Filters: []template.Filter{{Name: "q"}}}},
+		},
+		{
+			"ls {path:-/tmp|dq}",
+			[]template.Key{{Index: 3, Str: "path", Default: "/tmp", HasDefault: true, Filters: []template.Filter{{Name: "dq"}}}},
+		},
+		{
+			"run {args| raw }",
+			[]template.Key{{Index: 4, Str: "args", Filters: []template.Filter{{Name: "raw"}}}},
+		},
+		{
+			"git checkout {branch|replace:/:-|lower}",
+			[]template.Key{{Index: 13, Str: "branch", Filters: []template.Filter{{Name: "replace", Args: []string{"/", "-"}}, {Name: "lower"}}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			tmpl, err := template.ParseTemplate(tt.str)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.keys, tmpl.Keys)
+		})
+	}
+}
+
+func TestParseFilterError(t *testing.T) {
+	var tests = []struct {
+		str    string
+		offset int
+		err    error
+	}{
+		{
+			"ls {path|}",
+			3,
+			errors.New("Empty filter"),
+		},
+		{
+			"ls {path|quote}",
+			3,
+			errors.New("Unknown filter 'quote'"),
+		},
+		{
+			"ls {path|lower:x}",
+			3,
+			errors.New("Filter 'lower' expects 0 arguments, got 1"),
+		},
+		{
+			"ls {path|replace:/}",
+			3,
+			errors.New("Filter 'replace' expects 2 arguments, got 1"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			_, err := template.ParseTemplate(tt.str)
+			assert.Equal(t, &template.ParseError{Template: tt.str, Offset: tt.offset, Err: tt.err}, err)
+		})
+	}
+}
+
+func TestApplyQuotingFilters(t *testing.T) {
+	var tests = []struct {
+		str    string
+		value  string
+		result string
+	}{
+		{"ls {v|q}", "two words", `ls 'two words'`},
+		{"ls {v|q}", "it's", `ls 'it'\''s'`},
+		{"ls {v|q}", `$HOME "quoted" \n`, `ls '$HOME "quoted" \n'`},
+		{"ls {v|q}", "", `ls ''`},
+		{"ls {v|dq}", "two words", `ls "two words"`},
+		{"ls {v|dq}", "it's", `ls "it's"`},
+		{"ls {v|dq}", `$HOME "quoted" \n`, `ls "\$HOME \"quoted\" \\n"`},
+		{"ls {v|dq}", "`whoami`", "ls \"\\`whoami\\`\""},
+		{"ls {v|raw}", `$HOME "quoted"`, `ls $HOME "quoted"`},
+		{"ls {v|raw|q}", "it's", `ls 'it'\''s'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str+" "+tt.value, func(t *testing.T) {
+			tmpl, err := template.ParseTemplate(tt.str)
+			assert.Nil(t, err)
+			res, mss, err := template.ApplyTemplate(tmpl, map[string]string{"v": tt.value})
+			assert.Nil(t, err)
+			assert.Equal(t, tt.result, res)
+			assert.Equal(t, []string(nil), mss)
+		})
+	}
+}
+
+func TestApplyTransformFilters(t *testing.T) {
+	var tests = []struct {
+		str    string
+		value  string
+		result string
+	}{
+		{"{v|lower}", "Feature/ABC", "feature/abc"},
+		{"{v|upper}", "Feature/abc", "FEATURE/ABC"},
+		{"{v|trim}", "  1.2.3\n", "1.2.3"},
+		{"{v|replace:/:-}", "feature/a/b", "feature-a-b"},
+		{"{v|replace:-:}", "a-b-c", "abc"},
+		{"{v|basename}", "/src/app/main.go", "main.go"},
+		{"{v|dirname}", "/src/app/main.go", "/src/app"},
+		{"{v|abs}", "/src/app/../main.go", "/src/main.go"},
+		{"{v|replace:/:-|lower|q}", "Feature/It's", "'feature-it'\\''s'"},
+		{"{v:-Default|lower}", "", "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str+" "+tt.value, func(t *testing.T) {
+			tmpl, err := template.ParseTemplate(tt.str)
+			assert.Nil(t, err)
+			values := map[string]string{"v": tt.value}
+			if len(tt.value) == 0 {
+				values = map[string]string{}
+			}
+			res, mss, err := template.ApplyTemplate(tmpl, values)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.result, res)
+			assert.Equal(t, []string(nil), mss)
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	var tests = []struct {
+		value  string
+		result string
+	}{
+		{"--out=build/x64", "--out=build/x64"},
+		{"", "''"},
+		{"two words", "'two words'"},
+		{"it's", `'it'\''s'`},
+		{"$HOME", "'$HOME'"},
+		{"é", "'é'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.result, template.ShellQuote(tt.value))
+		})
+	}
+}
@@ -2,79 +2,569 @@ package template
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"strconv"
 	"strings"
 )
 
+// separates a key name from its default value, e.g. {jobs:-8}
+const defaultSeparator = ":-"
+
+// separates a key from the filters applied to its value, e.g. {path|q}
+const filterSeparator = "|"
+
+// keys in this namespace are read from the process environment, e.g. {env:HOME}
+const EnvNamespace = "env"
+
+// keys in this namespace are single args forwarded to a task, e.g. {args[0]}
+// every forwarded arg is inserted with {args}, unless state has a value named args, which takes its place
+// their values are set with WithArgs
+const ArgsNamespace = "args"
+
+// the key WithArgs sets every forwarded arg to, it cannot be written in a template
+const allArgsKey = ArgsNamespace + "[*]"
+
 type Key struct {
-	Index int
-	Str   string
+	// byte offset in Template.Str where the value is inserted
+	Index      int
+	Str        string
+	Namespace  string
+	Default    string
+	HasDefault bool
+	Filters    []Filter
+}
+
+// whether the key is {args}, every forwarded arg, see ArgsNamespace
+func (key Key) IsAllArgs() bool {
+	return len(key.Namespace) == 0 && key.Str == ArgsNamespace
+}
+
+// the key as written in the template, including its namespace
+func (key Key) Name() string {
+	switch key.Namespace {
+	case "":
+		return key.Str
+	case ArgsNamespace:
+		return ArgsNamespace + "[" + key.Str + "]"
+	default:
+		return key.Namespace + ":" + key.Str
+	}
 }
 
 type Template struct {
-	Str  string
+	// the template string with tags removed and escaped braces unescaped
+	Str string
+	// every key inserted by the template, including the ones inside conditional sections
 	Keys []Key
+	// keys tested by conditional sections
+	CondKeys []Key
+	Nodes    []Node
+}
+
+const (
+	ifTag   = "if "
+	elseTag = "else"
+	endTag  = "end"
+)
+
+// a conditional section being parsed
+type frame struct {
+	cond   Cond
+	nodes  []Node
+	inElse bool
+	// rune offset of the {if} tag
+	start int
+}
+
+// returned by ParseTemplate, with the position of the brace the error was found at
+type ParseError struct {
+	// the template that could not be parsed
+	Template string
+	// rune offset in Template of the brace opening or closing the tag the error is about
+	Offset int
+	// the task the template is from, set by callers that know it
+	Task string
+	Err  error
+}
+
+func (err *ParseError) Error() string {
+	prefix := ""
+	if len(err.Task) > 0 {
+		prefix = fmt.Sprintf("task '%s' - ", err.Task)
+	}
+	line, character, _ := err.position()
+	// like jsonerror, but the line is left out of templates written on a single line
+	if strings.ContainsRune(err.Template, '\n') {
+		return fmt.Sprintf("%sline %d, character %d: %v", prefix, line, character, err.Err)
+	}
+	return fmt.Sprintf("%scharacter %d: %v", prefix, character, err.Err)
+}
+
+func (err *ParseError) Unwrap() error {
+	return err.Err
+}
+
+// returns the error followed by the line of the template it was found in, with a caret under the brace
+func (err *ParseError) Diagnostic() string {
+	runes := []rune(err.Template)
+	_, character, lineStart := err.position()
+	lineEnd := lineStart
+	for lineEnd < len(runes) && runes[lineEnd] != '\n' {
+		lineEnd++
+	}
+
+	var caret strings.Builder
+	for _, chr := range runes[lineStart : lineStart+character-1] {
+		// tabs are kept so the caret lines up with the line above it
+		if chr == '\t' {
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+	return fmt.Sprintf("%v\n    %s\n    %s^", err, string(runes[lineStart:lineEnd]), caret.String())
+}
+
+// returns the line and the character of Offset, counted from 1, and the rune offset its line starts at
+func (err *ParseError) position() (int, int, int) {
+	runes := []rune(err.Template)
+	offset := err.Offset
+	if offset > len(runes) {
+		offset = len(runes)
+	}
+	line := 1
+	lineStart := 0
+	for i, chr := range runes[:offset] {
+		if chr == '\n' {
+			line++
+			lineStart = i + 1
+		}
+	}
+	return line, offset - lineStart + 1, lineStart
 }
 
+// braces are escaped by doubling them: "{{" is a literal '{' and "}}" is a literal '}'
+// the tags "{if <cond>}", "{else}" and "{end}" delimit conditional sections, see Cond
 func ParseTemplate(str string) (Template, error) {
 	template := Template{}
-	curKey := ""
-	curKeyIndex := 0
+	curTag := ""
+	curTagIndex := 0
+	// rune offset of the opening brace of the current tag
+	curTagStart := 0
 
-	index := 0
-	activeKey := false
+	// frames[0] holds the top level nodes
+	frames := []frame{{}}
+	activeTag := false
 	var builder strings.Builder
+	var text strings.Builder
 	builder.Grow(len(str))
-	for _, chr := range str {
+	flushText := func() {
+		if text.Len() > 0 {
+			frames[len(frames)-1].nodes = append(frames[len(frames)-1].nodes, Text(text.String()))
+			text.Reset()
+		}
+	}
+
+	runes := []rune(str)
+	parseError := func(offset int, err error) (Template, error) {
+		return Template{}, &ParseError{Template: str, Offset: offset, Err: err}
+	}
+	for i := 0; i < len(runes); i++ {
+		chr := runes[i]
+		escaped := !activeTag && i+1 < len(runes) && runes[i+1] == chr
 		switch chr {
 
 		case '{':
-			if activeKey {
-				return Template{}, errors.New("Found opening '{' before closing '}'")
+			if escaped {
+				i += 1
+				break
+			}
+			if activeTag {
+				return parseError(i, errors.New("Found opening '{' before closing '}'"))
 			}
-			curKeyIndex = index
-			activeKey = true
+			curTagIndex = builder.Len()
+			curTagStart = i
+			activeTag = true
 			continue
 		case '}':
-			if activeKey && len(curKey) == 0 {
-				return Template{}, errors.New("Empty Key")
+			if escaped {
+				i += 1
+				break
 			}
-			if !activeKey {
-				return Template{}, errors.New("Found closing '}' before opening '{'")
+			if !activeTag {
+				return parseError(i, errors.New("Found closing '}' before opening '{'"))
 			}
-			template.Keys = append(template.Keys, Key{curKeyIndex, curKey})
-			activeKey = false
-			curKey = ""
+			flushText()
+			top := &frames[len(frames)-1]
+			switch {
+
+			case strings.HasPrefix(curTag, ifTag):
+				cond, err := parseCond(curTagIndex, strings.TrimSpace(curTag[len(ifTag):]))
+				if err != nil {
+					return parseError(curTagStart, err)
+				}
+				template.CondKeys = append(template.CondKeys, cond.Key)
+				frames = append(frames, frame{cond: cond, start: curTagStart})
+
+			case curTag == elseTag:
+				if len(frames) == 1 {
+					return parseError(curTagStart, errors.New("Found {else} outside of {if}"))
+				}
+				if top.inElse {
+					return parseError(curTagStart, errors.New("Found second {else} in {if}"))
+				}
+				top.cond.Then = top.nodes
+				top.nodes = nil
+				top.inElse = true
+
+			case curTag == endTag:
+				if len(frames) == 1 {
+					return parseError(curTagStart, errors.New("Found {end} without {if}"))
+				}
+				if top.inElse {
+					top.cond.Else = top.nodes
+				} else {
+					top.cond.Then = top.nodes
+				}
+				cond := top.cond
+				frames = frames[:len(frames)-1]
+				frames[len(frames)-1].nodes = append(frames[len(frames)-1].nodes, cond)
+
+			default:
+				key, err := parseKey(curTagIndex, curTag)
+				if err != nil {
+					return parseError(curTagStart, err)
+				}
+				template.Keys = append(template.Keys, key)
+				top.nodes = append(top.nodes, key)
+			}
+			activeTag = false
+			curTag = ""
 			continue
 		default:
-			if activeKey {
-				curKey += string(chr)
+			if activeTag {
+				curTag += string(chr)
 				continue
 			}
 		}
-		index += 1
 		builder.WriteRune(chr)
+		text.WriteRune(chr)
+	}
+	if activeTag {
+		return parseError(curTagStart, errors.New("Found opening '{' without closing '}'"))
 	}
+	if len(frames) > 1 {
+		return parseError(frames[len(frames)-1].start, errors.New("Found {if} without closing {end}"))
+	}
+	flushText()
 	template.Str = builder.String()
+	template.Nodes = frames[0].nodes
 	return template, nil
 }
 
-// returns the string with values applied, and a list of keys that were not found in the input map
-// most applications would consider len(missing) > 0 to be an error
-func ApplyTemplate(tmpl Template, values map[string]string) (string, []string) {
-	var missing []string
-	var lastIndex int
+// parses the condition of an {if} tag: a key, optionally followed by "=value" or "!=value"
+// the value follows the filters of the key, it can contain '=' and '|'
+func parseCond(index int, str string) (Cond, error) {
+	cond := Cond{}
+	keyStr := str
+	opIndex := strings.Index(str, "=")
+	if pipe := strings.Index(str, filterSeparator); pipe >= 0 && (opIndex < 0 || pipe < opIndex) {
+		opIndex = condOpIndex(str, pipe)
+	}
+	if opIndex >= 0 {
+		keyStr = str[:opIndex]
+		cond.Op = "="
+		if strings.HasSuffix(keyStr, "!") {
+			keyStr = keyStr[:len(keyStr)-1]
+			cond.Op = "!="
+		}
+		cond.Value = str[opIndex+1:]
+	}
+
+	key, err := parseKey(index, strings.TrimSpace(keyStr))
+	if err != nil {
+		return Cond{}, err
+	}
+	cond.Key = key
+	return cond, nil
+}
+
+// returns the index of the '=' of a condition whose key has filters, starting at pipe, or -1 when there is none
+// the arguments of a filter can contain '=', except for its last one: the first '=' in it starts the value
+func condOpIndex(str string, pipe int) int {
+	start := pipe + 1
+	for {
+		segment := str[start:]
+		end := strings.Index(segment, filterSeparator)
+		if end >= 0 {
+			segment = segment[:end]
+		}
+		// unknown filters take no arguments here, parseKey reports them
+		name, _, _ := strings.Cut(segment, filterArgSeparator)
+		parts := strings.SplitN(segment, filterArgSeparator, filters[strings.TrimSpace(name)].nargs+1)
+		last := parts[len(parts)-1]
+		if i := strings.Index(last, "="); i >= 0 {
+			return start + len(segment) - len(last) + i
+		}
+		if end < 0 {
+			return -1
+		}
+		start += end + 1
+	}
+}
+
+// parses the content between braces, splitting off the filters and default value if there are any
+func parseKey(index int, str string) (Key, error) {
+	parts := strings.Split(str, filterSeparator)
+	key := Key{Index: index, Str: parts[0]}
+	if name, def, found := strings.Cut(parts[0], defaultSeparator); found {
+		key.Str = name
+		key.Default = def
+		key.HasDefault = true
+	}
+	if len(key.Str) == 0 {
+		return Key{}, errors.New("Empty Key")
+	}
+
+	if name, found := strings.CutPrefix(key.Str, EnvNamespace+":"); found {
+		key.Str = name
+		key.Namespace = EnvNamespace
+		if len(key.Str) == 0 {
+			return Key{}, errors.New("Empty Key")
+		}
+	} else if index, found := strings.CutPrefix(key.Str, ArgsNamespace+"["); found && strings.HasSuffix(index, "]") {
+		index = strings.TrimSuffix(index, "]")
+		if _, err := strconv.ParseUint(index, 10, 0); err != nil {
+			return Key{}, fmt.Errorf("Invalid args index '%s'", index)
+		}
+		key.Str = index
+		key.Namespace = ArgsNamespace
+	}
+
+	for _, part := range parts[1:] {
+		filter, err := parseFilter(part)
+		if err != nil {
+			return Key{}, err
+		}
+		key.Filters = append(key.Filters, filter)
+	}
+	return key, nil
+}
+
+// returned when state values reference each other in a loop, e.g. a = "{b}" and b = "{a}"
+type CycleError struct {
+	// the chain of references, starting and ending with the same key
+	Chain []string
+}
+
+func (err *CycleError) Error() string {
+	return "state keys reference each other in a cycle: " + strings.Join(err.Chain, " -> ")
+}
+
+// expands state values that are themselves templates, e.g. out_dir = "build/{flavor}"
+type resolver struct {
+	values    map[string]string
+	resolved  map[string]string
+	chain     []string
+	defaulted []Key
+	// the forwarded args inserted, see PlacedArgs
+	allArgsPlaced bool
+	placedArgs    []int
+	// when set, the values of the template's own keys are written as placeholders and collected here
+	placeholders *[]placeholderValue
+}
+
+func newResolver(values map[string]string) *resolver {
+	return &resolver{values: values, resolved: map[string]string{}}
+}
+
+func (r *resolver) apply(tmpl Template) (string, []string, error) {
 	var builder strings.Builder
-	for _, key := range tmpl.Keys {
-		val, prs := values[key.Str]
-		if !prs {
-			missing = append(missing, key.Str)
-			continue
+	missing, err := r.applyNodes(tmpl.Nodes, &builder)
+	if err != nil {
+		return "", nil, err
+	}
+	return builder.String(), missing, nil
+}
+
+func (r *resolver) applyNodes(nodes []Node, builder *strings.Builder) ([]string, error) {
+	var missing []string
+	for _, node := range nodes {
+		switch node := node.(type) {
+
+		case Text:
+			builder.WriteString(string(node))
+
+		case Key:
+			val, prs, nestedMissing, err := r.lookup(node)
+			if err != nil {
+				return nil, err
+			}
+			missing = append(missing, nestedMissing...)
+			if !prs {
+				if !node.HasDefault {
+					missing = append(missing, node.Name())
+					continue
+				}
+				val = node.Default
+				r.defaulted = append(r.defaulted, node)
+			} else if node.Namespace == ArgsNamespace {
+				index, _ := strconv.Atoi(node.Str)
+				r.placedArgs = append(r.placedArgs, index)
+			} else if r.isForwardedArgs(node) {
+				r.allArgsPlaced = true
+			}
+			val = applyFilters(node.Filters, val)
+			if r.placeholders != nil && len(r.chain) == 0 {
+				builder.WriteString(placeholder(len(*r.placeholders)))
+				*r.placeholders = append(*r.placeholders, placeholderValue{
+					val:    val,
+					splice: r.isForwardedArgs(node) && len(node.Filters) == 0,
+				})
+			} else {
+				builder.WriteString(val)
+			}
+
+		case Cond:
+			val, prs, nestedMissing, err := r.lookup(node.Key)
+			if err != nil {
+				return nil, err
+			}
+			missing = append(missing, nestedMissing...)
+			if !prs && node.Key.HasDefault {
+				val, prs = node.Key.Default, true
+				r.defaulted = append(r.defaulted, node.Key)
+			}
+			if prs {
+				val = applyFilters(node.Key.Filters, val)
+			}
+
+			branch := node.Else
+			if node.eval(val, prs) {
+				branch = node.Then
+			}
+			branchMissing, err := r.applyNodes(branch, builder)
+			if err != nil {
+				return nil, err
+			}
+			missing = append(missing, branchMissing...)
+		}
+	}
+	return missing, nil
+}
+
+// whether the key is {args} and its value is the forwarded args, not a state value named args
+func (r *resolver) isForwardedArgs(key Key) bool {
+	_, isState := r.values[ArgsNamespace]
+	return key.IsAllArgs() && !isState
+}
+
+// finds the value of a key, from the input map or the environment depending on its namespace
+// values from the input map are expanded, along with missing keys they reference
+func (r *resolver) lookup(key Key) (string, bool, []string, error) {
+	if key.Namespace == EnvNamespace {
+		val, prs := os.LookupEnv(key.Str)
+		return val, prs, nil, nil
+	}
+	// args are used as they were given, they are never expanded
+	if key.Namespace == ArgsNamespace {
+		val, prs := r.values[key.Name()]
+		return val, prs, nil, nil
+	}
+
+	raw, prs := r.values[key.Str]
+	if !prs {
+		if key.IsAllArgs() {
+			val, prs := r.values[allArgsKey]
+			return val, prs, nil, nil
 		}
+		return "", false, nil, nil
+	}
+	if val, found := r.resolved[key.Str]; found {
+		return val, true, nil, nil
+	}
 
-		builder.WriteString(tmpl.Str[lastIndex:key.Index])
-		builder.WriteString(val)
-		lastIndex = key.Index
+	for i, name := range r.chain {
+		if name == key.Str {
+			chain := append([]string{}, r.chain[i:]...)
+			return "", false, nil, &CycleError{append(chain, key.Str)}
+		}
+	}
+
+	tmpl, err := ParseTemplate(raw)
+	if err != nil {
+		return "", false, nil, fmt.Errorf("state key '%s' is not a valid template: %w", key.Str, err)
+	}
+
+	r.chain = append(r.chain, key.Str)
+	val, missing, err := r.apply(tmpl)
+	r.chain = r.chain[:len(r.chain)-1]
+	if err != nil {
+		return "", false, nil, err
 	}
-	builder.WriteString(tmpl.Str[lastIndex:])
-	return builder.String(), missing
+
+	// keys missing from the value are only reported the first time it is used
+	r.resolved[key.Str] = val
+	return val, true, missing, nil
+}
+
+// returns the string with values applied, and a list of keys that were not found in the input map
+// conditional sections are only included when their condition holds, keys they test are never missing
+// keys with a default value use it when they are not found, and are never reported as missing
+// keys in the env namespace are looked up in the environment instead of the input map
+// values in the input map can reference other values, they are expanded recursively
+// an error is returned if those references form a cycle or are not valid templates
+// most applications would consider len(missing) > 0 to be an error
+func ApplyTemplate(tmpl Template, values map[string]string) (string, []string, error) {
+	return newResolver(values).apply(tmpl)
+}
+
+// returns a copy of the input map with the values of the args namespace set from the forwarded args
+// {args} is every arg quoted for a POSIX shell and separated by spaces, {args[i]} is a single arg as it was given
+// a state value named args in the input map is kept, {args} inserts it instead of the forwarded args
+func WithArgs(values map[string]string, args []string) map[string]string {
+	withArgs := make(map[string]string, len(values)+len(args)+1)
+	for key, val := range values {
+		withArgs[key] = val
+	}
+
+	for i, arg := range args {
+		withArgs[Key{Str: strconv.Itoa(i), Namespace: ArgsNamespace}.Name()] = arg
+	}
+	withArgs[allArgsKey] = QuoteArgs(args)
+	return withArgs
+}
+
+// returns the args quoted for a POSIX shell and separated by spaces, as {args} inserts them
+func QuoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = ShellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// returns the forwarded args ApplyTemplate inserts, all is true when {args} inserts every one of them,
+// otherwise placed holds the indexes of the ones inserted with {args[i]}, including through state values
+// args in conditional sections that are not included are not placed, {args} is not either when the input map
+// has a state value named args
+func PlacedArgs(tmpl Template, values map[string]string) (all bool, placed []int) {
+	r := newResolver(values)
+	r.apply(tmpl)
+	return r.allArgsPlaced, r.placedArgs
+}
+
+// returns the fully expanded value of a key in the input map, see ApplyTemplate
+func ResolveValue(name string, values map[string]string) (string, []string, error) {
+	key := Key{Str: name}
+	return ApplyTemplate(Template{Keys: []Key{key}, Nodes: []Node{key}}, values)
+}
+
+// returns the keys that ApplyTemplate would fill in with their default value,
+// including the ones referenced by values in the input map
+func DefaultedKeys(tmpl Template, values map[string]string) []Key {
+	r := newResolver(values)
+	r.apply(tmpl)
+	return r.defaulted
 }
@@ -17,12 +17,46 @@ func TestParseTemplateHappy(t *testing.T) {
 		{
 			"my {adjective} template!",
 			"my  template!",
-			[]template.Key{{3, "adjective"}},
+			[]template.Key{{Index: 3, Str: "adjective"}},
 		},
 		{
 			"more {than} one {tmpl}",
 			"more  one ",
-			[]template.Key{{5, "than"}, {10, "tmpl"}},
+			[]template.Key{{Index: 5, Str: "than"}, {Index: 10, Str: "tmpl"}},
+		},
+		{
+			"make -j{jobs:-8} {target:-}",
+			"make -j ",
+			[]template.Key{{Index: 7, Str: "jobs", Default: "8", HasDefault: true}, {Index: 8, Str: "target", HasDefault: true}},
+		},
+		{
+			"cp {file}.{{c,h}} {dest}",
+			"cp .{c,h} ",
+			[]template.Key{{Index: 3, Str: "file"}, {Index: 10, Str: "dest"}},
+		},
+		{
+			"awk '{{print $1}}' {file}",
+			"awk '{print $1}' ",
+			[]template.Key{{Index: 17, Str: "file"}},
+		},
+		{
+			"{{{key}}}",
+			"{}",
+			[]template.Key{{Index: 1, Str: "key"}},
+		},
+		{
+			"écho {{ {clé} }}",
+			"écho {  }",
+			[]template.Key{{Index: 8, Str: "clé"}},
+		},
+		{
+			"{env:CC:-gcc} -o {out} {env:CFLAGS}",
+			" -o  ",
+			[]template.Key{
+				{Index: 0, Str: "CC", Namespace: "env", Default: "gcc", HasDefault: true},
+				{Index: 4, Str: "out"},
+				{Index: 5, Str: "CFLAGS", Namespace: "env"},
+			},
 		},
 	}
 	for _, tt := range tests {
@@ -37,26 +71,89 @@ func TestParseTemplateHappy(t *testing.T) {
 
 func TestParseTemplateError(t *testing.T) {
 	var tests = []struct {
-		str string
-		err error
+		str    string
+		offset int
+		err    error
 	}{
 		{
 			"my {a{djective} template!",
+			5,
 			errors.New("Found opening '{' before closing '}'"),
 		},
 		{
 			"my {} template!",
+			3,
+			errors.New("Empty Key"),
+		},
+		{
+			"my {:-default} template!",
+			3,
 			errors.New("Empty Key"),
 		},
+		{
+			"my {env:} template!",
+			3,
+			errors.New("Empty Key"),
+		},
+		{
+			"my {args[first]} template!",
+			3,
+			errors.New("Invalid args index 'first'"),
+		},
 		{
 			"my } template!",
+			3,
+			errors.New("Found closing '}' before opening '{'"),
+		},
+		{
+			"my {{adjective} template!",
+			14,
 			errors.New("Found closing '}' before opening '{'"),
 		},
+		{
+			"my {adjective template!",
+			3,
+			errors.New("Found opening '{' without closing '}'"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			_, err := template.ParseTemplate(tt.str)
+			assert.Equal(t, &template.ParseError{Template: tt.str, Offset: tt.offset, Err: tt.err}, err)
+		})
+	}
+}
+
+func TestParseErrorDiagnostic(t *testing.T) {
+	var tests = []struct {
+		str      string
+		task     string
+		expected string
+	}{
+		{
+			"my {a{djective} template!",
+			"",
+			"character 6: Found opening '{' before closing '}'\n    my {a{djective} template!\n         ^",
+		},
+		{
+			"échö {}",
+			"greet",
+			"task 'greet' - character 6: Empty Key\n    échö {}\n         ^",
+		},
+		{
+			"cmake -B build\n\tcmake --build {if x}build",
+			"build",
+			"task 'build' - line 2, character 16: Found {if} without closing {end}\n    \tcmake --build {if x}build\n    \t              ^",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.str, func(t *testing.T) {
 			_, err := template.ParseTemplate(tt.str)
-			assert.Equal(t, tt.err, err)
+			var parseErr *template.ParseError
+			if assert.True(t, errors.As(err, &parseErr)) {
+				parseErr.Task = tt.task
+				assert.Equal(t, tt.expected, parseErr.Diagnostic())
+			}
 		})
 	}
 }
@@ -77,11 +174,27 @@ func TestApplyTemplateHappy(t *testing.T) {
 			map[string]string{"you": "Mark", "me": "Frank"},
 			"Hi Mark I am Frank, nice to meet you",
 		},
+		{
+			"make -j{jobs:-8} --config {config:-debug}",
+			map[string]string{"config": "release"},
+			"make -j8 --config release",
+		},
+		{
+			"curl -d '{{\"name\": \"{name}\"}}' {url}",
+			map[string]string{"name": "stask", "url": "localhost"},
+			"curl -d '{\"name\": \"stask\"}' localhost",
+		},
+		{
+			"écho {{ {clé} }}",
+			map[string]string{"clé": "valeur"},
+			"écho { valeur }",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.str, func(t *testing.T) {
 			tmpl, _ := template.ParseTemplate(tt.str)
-			res, mss := template.ApplyTemplate(tmpl, tt.values)
+			res, mss, err := template.ApplyTemplate(tmpl, tt.values)
+			assert.Nil(t, err)
 			assert.Equal(t, tt.result, res)
 			assert.Equal(t, []string(nil), mss)
 		})
@@ -104,12 +217,271 @@ func TestApplyTemplateError(t *testing.T) {
 			map[string]string{"them": "Mark", "us": "Frank"},
 			[]string{"you", "me"},
 		},
+		{
+			"{cmd:-make} {target}",
+			map[string]string{},
+			[]string{"target"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.str, func(t *testing.T) {
 			tmpl, _ := template.ParseTemplate(tt.str)
-			_, mss := template.ApplyTemplate(tmpl, tt.values)
+			_, mss, err := template.ApplyTemplate(tmpl, tt.values)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.missing, mss)
+		})
+	}
+}
+
+func TestDefaultedKeys(t *testing.T) {
+	tmpl, err := template.ParseTemplate("make -j{jobs:-8} --config {config:-debug} {target}")
+	assert.Nil(t, err)
+	defaulted := template.DefaultedKeys(tmpl, map[string]string{"config": "release"})
+	assert.Equal(t, []template.Key{{Index: 7, Str: "jobs", Default: "8", HasDefault: true}}, defaulted)
+}
+
+func TestApplyTemplateEnv(t *testing.T) {
+	t.Setenv("STASK_TEST_BUILD_ID", "42")
+	t.Setenv("STASK_TEST_EMPTY", "")
+
+	var tests = []struct {
+		str     string
+		values  map[string]string
+		result  string
+		missing []string
+	}{
+		{
+			"build {env:STASK_TEST_BUILD_ID} {flavor}",
+			map[string]string{"flavor": "debug"},
+			"build 42 debug",
+			nil,
+		},
+		{
+			"[{env:STASK_TEST_EMPTY:-unused}]",
+			map[string]string{},
+			"[]",
+			nil,
+		},
+		{
+			"build {env:STASK_TEST_UNSET:-0}",
+			map[string]string{},
+			"build 0",
+			nil,
+		},
+		{
+			"build {env:STASK_TEST_BUILD_ID}",
+			map[string]string{"STASK_TEST_BUILD_ID": "state is not used"},
+			"build 42",
+			nil,
+		},
+		{
+			"build {env:STASK_TEST_UNSET} {STASK_TEST_BUILD_ID}",
+			map[string]string{},
+			"build  ",
+			[]string{"env:STASK_TEST_UNSET", "STASK_TEST_BUILD_ID"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			tmpl, err := template.ParseTemplate(tt.str)
+			assert.Nil(t, err)
+			res, mss, err := template.ApplyTemplate(tmpl, tt.values)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.result, res)
 			assert.Equal(t, tt.missing, mss)
 		})
 	}
 }
+
+func TestApplyTemplateNested(t *testing.T) {
+	var tests = []struct {
+		str     string
+		values  map[string]string
+		result  string
+		missing []string
+	}{
+		{
+			"cd {out_dir}",
+			map[string]string{"out_dir": "build/{flavor}/{arch}", "flavor": "debug", "arch": "x64"},
+			"cd build/debug/x64",
+			nil,
+		},
+		{
+			"cd {out_dir|upper} && ls {out_dir}",
+			map[string]string{"out_dir": "{root}/{flavor}", "root": "{home}/build", "home": "/h", "flavor": "rel"},
+			"cd /H/BUILD/REL && ls /h/build/rel",
+			nil,
+		},
+		{
+			"cd {out_dir}",
+			map[string]string{"out_dir": "build/{flavor:-debug}/{{arch}}"},
+			"cd build/debug/{arch}",
+			nil,
+		},
+		{
+			"cd {out_dir}",
+			map[string]string{"out_dir": "build/{flavor}/{arch}", "arch": "x64"},
+			"cd ",
+			[]string{"flavor"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			tmpl, err := template.ParseTemplate(tt.str)
+			assert.Nil(t, err)
+			res, mss, err := template.ApplyTemplate(tmpl, tt.values)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.missing, mss)
+			if len(tt.missing) == 0 {
+				assert.Equal(t, tt.result, res)
+			}
+		})
+	}
+}
+
+func TestApplyTemplateNestedError(t *testing.T) {
+	var tests = []struct {
+		str    string
+		values map[string]string
+		err    string
+	}{
+		{
+			"cd {a}",
+			map[string]string{"a": "{b}/x", "b": "{c}", "c": "y{a}"},
+			"state keys reference each other in a cycle: a -> b -> c -> a",
+		},
+		{
+			"cd {a}",
+			map[string]string{"a": "{b}/x", "b": "{b}"},
+			"state keys reference each other in a cycle: b -> b",
+		},
+		{
+			"cd {a}",
+			map[string]string{"a": "awk 'print $1}'"},
+			"state key 'a' is not a valid template: character 14: Found closing '}' before opening '{'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			tmpl, err := template.ParseTemplate(tt.str)
+			assert.Nil(t, err)
+			_, _, err = template.ApplyTemplate(tmpl, tt.values)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestResolveValue(t *testing.T) {
+	values := map[string]string{"out_dir": "build/{flavor}", "flavor": "{env:STASK_TEST_FLAVOR:-debug}"}
+	val, mss, err := template.ResolveValue("out_dir", values)
+	assert.Nil(t, err)
+	assert.Equal(t, []string(nil), mss)
+	assert.Equal(t, "build/debug", val)
+
+	tmpl, _ := template.ParseTemplate("{out_dir}")
+	defaulted := template.DefaultedKeys(tmpl, values)
+	assert.Equal(t, []template.Key{{Index: 0, Str: "STASK_TEST_FLAVOR", Namespace: "env", Default: "debug", HasDefault: true}}, defaulted)
+}
+
+func TestApplyTemplateWithArgs(t *testing.T) {
+	var tests = []struct {
+		str     string
+		fwd     []string
+		result  string
+		missing []string
+	}{
+		{
+			"grep {args} src",
+			[]string{"-i", "two words", "it's", "$HOME"},
+			`grep -i 'two words' 'it'\''s' '$HOME' src`,
+			nil,
+		},
+		{
+			"grep{if args} {args}{else} -r TODO{end} src",
+			nil,
+			"grep -r TODO src",
+			nil,
+		},
+		{
+			"grep -e {args[1]|q} {args[0]} {args[2]:-.}",
+			[]string{"src", "two words"},
+			"grep -e 'two words' src .",
+			nil,
+		},
+		{
+			"grep {args[1]}",
+			[]string{"src"},
+			"",
+			[]string{"args[1]"},
+		},
+		{
+			"echo {msg}",
+			[]string{"{nested}"},
+			"echo {nested}",
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			tmpl, err := template.ParseTemplate(tt.str)
+			assert.Nil(t, err)
+			values := template.WithArgs(map[string]string{"msg": "{args[0]}"}, tt.fwd)
+			res, mss, err := template.ApplyTemplate(tmpl, values)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.missing, mss)
+			if len(tt.missing) == 0 {
+				assert.Equal(t, tt.result, res)
+			}
+		})
+	}
+}
+
+func TestPlacedArgs(t *testing.T) {
+	var tests = []struct {
+		str    string
+		all    bool
+		placed []int
+	}{
+		{"grep {args} src", true, nil},
+		{"grep {args[1]} {args[0]|q}", false, []int{1, 0}},
+		{"echo {msg}", false, []int{0}},
+		{"grep src", false, nil},
+		// conditional sections that are not included do not place their args
+		{"grep{if verbose} {args}{end} src", false, nil},
+		{"grep{if args} {args[0]}{end} src", false, []int{0}},
+		{"grep {args[5]:-.}", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			tmpl, err := template.ParseTemplate(tt.str)
+			assert.Nil(t, err)
+			values := template.WithArgs(map[string]string{"msg": "{args[0]}"}, []string{"a", "b"})
+			all, placed := template.PlacedArgs(tmpl, values)
+			assert.Equal(t, tt.all, all)
+			assert.Equal(t, tt.placed, placed)
+		})
+	}
+}
+
+func TestApplyTemplateStateArgs(t *testing.T) {
+	// a state value named args takes the place of the forwarded args
+	values := template.WithArgs(map[string]string{"args": "--state"}, []string{"two words"})
+
+	tmpl, err := template.ParseTemplate("grep {args} src")
+	assert.Nil(t, err)
+	all, placed := template.PlacedArgs(tmpl, values)
+	assert.False(t, all)
+	assert.Empty(t, placed)
+	res, mss, err := template.ApplyTemplate(tmpl, values)
+	assert.Nil(t, err)
+	assert.Empty(t, mss)
+	assert.Equal(t, "grep --state src", res)
+
+	tmpl, err = template.ParseTemplate("grep {args[0]|q} src")
+	assert.Nil(t, err)
+	_, placed = template.PlacedArgs(tmpl, values)
+	assert.Equal(t, []int{0}, placed)
+	res, _, err = template.ApplyTemplate(tmpl, values)
+	assert.Nil(t, err)
+	assert.Equal(t, "grep 'two words' src", res)
+}
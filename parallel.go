@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/itsfrank/stask/internal/prefixwriter"
+)
+
+// colors cycled through to tell parallel tasks apart
+var prefixColors = []string{"36", "33", "35", "32", "34", "31"}
+
+// a task of a parallel group, running its steps one after the other
+type parallelChild struct {
+	task formattedTask
+
+	mu sync.Mutex
+	// the step currently running
+	cmd *exec.Cmd
+	// set when the task is told to stop, no more steps are started after that
+	stopped bool
+}
+
+// sends a signal to the running step, and prevents the next steps from starting
+func (child *parallelChild) stop(sig os.Signal) {
+	child.mu.Lock()
+	defer child.mu.Unlock()
+	child.stopped = true
+	if child.cmd != nil {
+		signalCommand(child.cmd, sig)
+	}
+}
+
+// runs the steps of the task with output prefixed by its name, returning the exit code of the first one that fails
+func (child *parallelChild) run(prefix string, mu *sync.Mutex) int {
+	stdout := prefixwriter.New(os.Stdout, prefix, mu)
+	stderr := prefixwriter.New(os.Stderr, prefix, mu)
+	defer stdout.Flush()
+	defer stderr.Flush()
+
+	for i, step := range child.task.steps {
+		if len(child.task.steps) > 1 {
+			fmt.Fprintf(stderr, "stask: [%d/%d] %s\n", i+1, len(child.task.steps), step.String())
+		}
+
+		// an interactive shell outside of the foreground process group stops when it takes the terminal
+		cmd := newCommand(child.task, step, false)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		setProcessGroup(cmd)
+
+		child.mu.Lock()
+		if child.stopped {
+			child.mu.Unlock()
+			return 1
+		}
+		err := cmd.Start()
+		child.cmd = cmd
+		child.mu.Unlock()
+
+		exitCode := waitCommand(cmd, err)
+		if exitCode == 0 {
+			continue
+		}
+		if step.continueOnError {
+			fmt.Fprintf(stderr, "stask: step %d failed with exit code %d, continuing\n", i+1, exitCode)
+			continue
+		}
+		return exitCode
+	}
+	return 0
+}
+
+// runs the tasks of a parallel group at the same time, exiting with the exit code of the first one that fails
+// interrupts are forwarded to every task, and with failFast the other tasks are stopped as soon as one fails
+func runParallel(group formattedTask) {
+	width := 0
+	for _, task := range group.parallel {
+		if len(task.name) > width {
+			width = len(task.name)
+		}
+	}
+
+	color := useColor()
+	children := make([]*parallelChild, len(group.parallel))
+	for i, task := range group.parallel {
+		children[i] = &parallelChild{task: task}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				for _, child := range children {
+					child.stop(sig)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	type result struct {
+		name     string
+		exitCode int
+		stopped  bool
+	}
+	results := make(chan result, len(children))
+	var outputMu sync.Mutex
+	for i, child := range children {
+		prefix := fmt.Sprintf("%-*s | ", width, child.task.name)
+		if color {
+			prefix = "\x1b[" + prefixColors[i%len(prefixColors)] + "m" + prefix + "\x1b[0m"
+		}
+		go func(child *parallelChild, prefix string) {
+			exitCode := child.run(prefix, &outputMu)
+			child.mu.Lock()
+			defer child.mu.Unlock()
+			results <- result{child.task.name, exitCode, child.stopped}
+		}(child, prefix)
+	}
+
+	exitCode := 0
+	var failed, stopped []string
+	for range children {
+		result := <-results
+		if result.exitCode == 0 {
+			continue
+		}
+		if result.stopped {
+			stopped = append(stopped, result.name)
+		} else {
+			failed = append(failed, result.name)
+		}
+		if exitCode == 0 {
+			exitCode = result.exitCode
+			if group.failFast {
+				for _, child := range children {
+					child.stop(syscall.SIGTERM)
+				}
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "stask: parallel tasks failed: %s\n", strings.Join(failed, ", "))
+	}
+	if len(stopped) > 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "stask: parallel tasks stopped: %s\n", strings.Join(stopped, ", "))
+	}
+	if exitCode != 0 {
+		exitWithError(&taskFailedError{group.name, exitCode})
+	}
+}
+
+// only color prefixes when writing to a terminal, and when NO_COLOR is not set
+func useColor() bool {
+	if _, found := os.LookupEnv("NO_COLOR"); found {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/itsfrank/stask/internal/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParallelInTerminal(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("uses the util-linux script command to run stask in a pseudo terminal")
+	}
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	if _, err := exec.LookPath("script"); err != nil {
+		t.Skip("script is not installed")
+	}
+
+	path := filepath.Join(t.TempDir(), "staskfile.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"Version": 1, "Tasks": {
+		"a": "echo from-a",
+		"b": "echo from-b",
+		"all": {"parallel": ["a", "b"]}
+	}}`), 0644))
+
+	// with the default flags, an interactive shell in a terminal stops if it is not in the foreground
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "script", "-qec", template.ShellQuote(os.Args[0])+" run all", "/dev/null")
+	cmd.Env = append(os.Environ(), "STASK_TEST_MAIN=1", "STASKFILE_PATH="+path, "SHELL="+bash,
+		"STASK_SHELL=", "STASK_SHELL_FLAGS=", "STASK_EXEC=", "NO_COLOR=1")
+	cmd.WaitDelay = time.Second
+	out, err := cmd.CombinedOutput()
+
+	assert.Nil(t, ctx.Err(), "stask did not exit, output: %s", out)
+	assert.Nil(t, err)
+	assert.Contains(t, string(out), "a | from-a")
+	assert.Contains(t, string(out), "b | from-b")
+}
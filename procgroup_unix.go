@@ -0,0 +1,34 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// starts the command in its own process group, so signals reach everything the shell starts
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func signalCommand(cmd *exec.Cmd, sig os.Signal) {
+	if cmd.Process == nil {
+		return
+	}
+	if sysSig, ok := sig.(syscall.Signal); ok {
+		syscall.Kill(-cmd.Process.Pid, sysSig)
+		return
+	}
+	cmd.Process.Signal(sig)
+}
+
+// returns the signal that killed the command, if it was killed by one
+func exitSignal(exerr *exec.ExitError) (int, bool) {
+	status, ok := exerr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return 0, false
+	}
+	return int(status.Signal()), true
+}
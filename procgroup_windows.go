@@ -0,0 +1,23 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// windows processes cannot be sent signals, they are killed instead
+func signalCommand(cmd *exec.Cmd, sig os.Signal) {
+	if cmd.Process == nil {
+		return
+	}
+	cmd.Process.Kill()
+}
+
+// windows processes are not killed by signals, a killed process has the exit code it was killed with
+func exitSignal(exerr *exec.ExitError) (int, bool) {
+	return 0, false
+}
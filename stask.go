@@ -7,7 +7,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"unicode"
 
 	"github.com/google/shlex"
 	"github.com/itsfrank/stask/internal/staskfile"
@@ -31,10 +33,16 @@ commands:
     tasks       show list of available tasks
     profile     list, show, load, save, delete profiles
     staskfile   print path to your staskfile
+    convert     rewrite your staskfile in another format
+    upgrade     upgrade your staskfile to the current "Version"
+    validate    check your staskfile and report every problem found
+    lint        warn about unused state, missing state and duplicate tasks
+    schema      print the JSON Schema of staskfiles
 
 other topics:
     syntax      how to author stask tasks
-    shell       how to configure what shel and shell flags stask will use`
+    shell       how to configure what shel and shell flags stask will use
+    exit        the exit codes of stask`
 
 const helpHelptext = `stask help - print help for stask command or topic
 
@@ -44,33 +52,52 @@ const helpInit = `stask init - create a default staskfile at the staskfile path
 
     note: will fail if file already exists
 
-    usage: stask init <topic>`
+    usage: stask init [--local]
+
+        --local: create a project staskfile (.stask.json) in the current directory instead`
 
 const stateHelptext = `stask state - print current stored state
 
-    usage: stask state`
+    usage: stask state [--resolved]
+
+        --resolved: print values with the state they reference expanded`
 
 const setHelptext = `stask set -  set a stored state value
 
-    usage: stask set <name> <value>`
+    usage: stask set [--layer <layer>] <name> <value>
+
+        --layer: the staskfile to write to, "global", "project" or "local" (see "stask help staskfile")
+                 defaults to the project staskfile if there is one, the global one otherwise`
 
 const clearHelptext = `stask clear - remove a stored state value
 
-    usage: stask clear <name>`
+    usage: stask clear [--layer <layer>] <name>
+
+        --layer: the staskfile to remove the value from, see "stask help set"`
+
+const runHelptext = `stask run - run a task using stored state, after the tasks it depends on
+
+    usage: stask run <task> [-s key=value]... [key=value]... [-- <fwd args>]
 
-const runHelptext = `stask run - run a task using stored state
+        -s, --set key=value: use value for key instead of the stored state, for this run only
+                             key=value pairs before '--' do the same, and right after it for keys
+                             the task or state uses: "stask run deploy -- env=staging"
 
-    usage: stask run <task> [-- <fwd args>]
+        fwd args: anything passed after a '--' is quoted for the shell and appended to the command of the task,
+                  or inserted where the task uses {args} or {args[0]}, see "stask help syntax"`
 
-        fwd args: anything passed after a '--' will be appended to the command of the task`
+const dryrunHelptext = `stask dryrun - print the commands that would be executed with "stask run", in order
 
-const dryrunHelptext = `stask dryrun - print the command that would be executed with "stask run"
+    usage: stask dryrun <task> [-s key=value]... [key=value]... [-- <fwd args>]
 
-    usage: stask dryrun <task> [-- <fwd args>]
+        -s, --set key=value: use value for key instead of the stored state, for this run only
+                             key=value pairs before '--' do the same, and right after it for keys
+                             the task or state uses: "stask dryrun deploy -- env=staging"
 
-        fwd args: anything passed after a '--' will be appended to the command of the task`
+        fwd args: anything passed after a '--' is quoted for the shell and appended to the command of the task,
+                  or inserted where the task uses {args} or {args[0]}, see "stask help syntax"`
 
-const tasksHelptext = `stask tasks - print list of available tasks
+const tasksHelptext = `stask tasks - print list of available tasks, with their description
 
     usage: stask tasks`
 
@@ -83,13 +110,107 @@ subcommands:
     show <name>   - print the state stored in the profile
     load <name>   - apply state stored in profile, overwiting only values in profile
     save <name>   - save current state as new profile
-    delete <name> - save current state as new profile`
+    delete <name> - save current state as new profile
+
+    load, save and delete accept "--layer <layer>" to choose the staskfile they write to, see "stask help set"`
+
+const staskfileHelptext = `stask staskfile - print the path to your staskfile, and why it was chosen
+
+    stask merges these staskfiles, entries from later ones take precedence:
+        global    ~/.config/stask/staskfile.json
+        project   .stask.json or staskfile.json, in the current directory or its parents up to your home directory
+        local     .stask.local.json, next to the project staskfile, for personal state kept out of git
+
+    each of them can be written in YAML (.yaml or .yml) or TOML (.toml) instead of JSON, see "stask help convert"
+    comments can be used in all of them, "//" and "/* */" in JSON, "#" in YAML and TOML
+    set, clear and profile only change the entries they write, tasks, comments and order are kept as written
+    (TOML staskfiles are written whole, without their comments)
+    a staskfile they cannot change that way, like a YAML staskfile written as a flow mapping, is left as it is
+    and they fail
+    staskfiles have a "Version", older staskfiles are upgraded in memory when stask reads them, and on disk
+    when set, clear or profile write to them or by "stask upgrade", the original is kept next to them as
+    "<staskfile>.v<version>.bak"
+    stask refuses staskfiles with a newer "Version" than it understands, update stask to use them
+    a hidden ".<staskfile>.lock" file is kept next to each staskfile stask writes, so commands run at the same
+    time do not lose each other's changes, add ".stask*.lock" to your .gitignore
+
+    tasks, state and profiles are merged by name, "stask state" and "stask tasks" show where each one comes from
+    a profile is used whole from the highest staskfile that has it, its keys are not merged with lower ones
+    set and profile load tell when a key they write is also set in a staskfile that takes precedence
+    the path in the "STASKFILE_PATH" environment variable replaces all of them
+
+    the printed staskfile is the one written to by default: the project staskfile if there is one, the global one otherwise
+    the layers are listed too when there is more than one
 
-const staskfileHelptext = `stask staskfile - print the path to your staskfile
+    usage: stask staskfile`
 
-    you can override the default location with the "STASKFILE_PATH" environment variable
+const convertHelptext = `stask convert - rewrite your staskfile in another format, the old file is removed
 
-    usage: stask staskfile`
+    usage: stask convert [--layer <layer>] <format>
+
+        format: "json", "yaml" or "toml", the format of a staskfile is chosen by its extension
+        --layer: the staskfile to convert, see "stask help set"
+
+    comments are not kept by convert, they are lost in the new staskfile
+    included staskfiles are not converted, each one can be converted on its own
+
+    a task with a multi-line command in YAML:
+	    Tasks:
+	        # configure and build
+	        build: |
+	            cmake -B build
+	            cmake --build build`
+
+const upgradeHelptext = `stask upgrade - upgrade your staskfile to the "Version" this stask writes
+
+    usage: stask upgrade [--layer <layer>]
+
+        --layer: the staskfile to upgrade, see "stask help set"
+
+    older staskfiles are upgraded in memory when stask reads them, and on disk when set, clear or profile
+    write to them, upgrade writes them now
+    the original is kept next to it as "<staskfile>.v<version>.bak"
+    comments and order are kept, except in TOML staskfiles and ones that cannot be edited in place,
+    which are written whole
+    included staskfiles are not upgraded, each one can be upgraded on its own`
+
+const validateHelptext = `stask validate - check a staskfile and report every problem found, with its position
+
+    usage: stask validate [path]
+
+        path: the staskfile to check, defaults to your staskfile (see "stask staskfile")
+
+    reported problems:
+        values of the wrong type, and keys stask does not know
+        tasks and state values that are not valid templates, see "stask help syntax"
+        dependencies on tasks that are not found
+        profiles setting keys that no task uses
+
+    exits with 65 when a problem is found, see "stask help exit"`
+
+const lintHelptext = `stask lint - warn about parts of your staskfiles that work, but are probably not written as meant
+
+    usage: stask lint [--strict]
+
+        --strict: exit with 1 when there are warnings, for CI
+                  also enabled by setting the "STASK_LINT_STRICT" environment variable to "1"
+
+    the merged staskfiles are checked (see "stask help staskfile"), warnings name the check that found them:
+        unused-state         state values that no task uses
+        missing-state        keys tasks use that are not in state or any profile, and have no default
+        unused-profile-key   keys profiles set that no task uses
+        duplicate-task       tasks that run identical commands
+        direct-split         tasks run without a shell whose command a shell would have run differently,
+                             like pipes, redirections, globs or "$VAR", see "stask help shell"
+
+    problems that stop stask from using a staskfile are reported by "stask validate"`
+
+const schemaHelptext = `stask schema - print the JSON Schema of staskfiles
+
+    usage: stask schema
+
+    editors use it to complete and check staskfiles, save it and point to it from the staskfile:
+        "$schema": "./staskfile.schema.json"`
 
 const syntaxHelptext = `stask task syntax:
     your staskfile has a "task" object, every field in that object is a runnable task
@@ -97,7 +218,77 @@ const syntaxHelptext = `stask task syntax:
     task definitions look like this:
 	    "my-task": "something {state} something else {other-state}"
 
-    stored state can be used in task by wrapping the name in braces {}`
+    stored state can be used in task by wrapping the name in braces {}
+
+    tasks can also be objects, to describe them or set where and how they run:
+	    "my-task": {
+	        "cmd": "something {state}",
+	        "desc": "shown by stask tasks",
+	        "cwd": "{repo}/sub",
+	        "env": {"CC": "{cc}"}
+	    }
+    cwd and env values can use state too, a relative cwd is relative to where stask is run
+    (or to the directory of its staskfile for included tasks)
+
+    a task can be a list of steps, they run in order and stask stops at the first one that fails
+    unless it sets "continue_on_error", forwarded args are appended to the last step:
+	    "rebuild": ["make clean", "make -j{jobs:-8}"]
+	    "rebuild": {"steps": [{"cmd": "make clean", "continue_on_error": true}, "make"], "desc": "..."}
+
+    tasks listed in "deps" run first, each one at most once, and stask stops at the first failure:
+	    "test": {"cmd": "ctest", "deps": ["configure", "build"]}
+
+    tasks listed in "parallel" run at the same time, their output prefixed with their name
+    the group fails if any of them fail, with "fail_fast" the others are stopped when one fails:
+	    "dev": {"parallel": ["frontend", "backend", "codegen"], "fail_fast": true}
+
+    other staskfiles can be included with a top-level "Include" list, relative to the including staskfile:
+	    "Include": ["~/team/stask/common.json", {"path": "./tools/stask.json", "as": "tools"}]
+    included tasks are named after their file (or "as"), e.g. "stask run common:build"
+    their state and profiles are used too, unless the including staskfile has the same keys
+
+    environment variables can be used with the "env:" prefix:
+	    "deploy": "scp {artifact} {env:USER}@{host}:"
+
+    state values can reference other state, they are expanded when the task runs:
+	    "out_dir": "build/{flavor}/{arch}"
+    literal braces in state values are escaped like in tasks: "awk '{{print $1}}'"
+
+    args forwarded after "--" are appended to the task, unless it places them itself:
+        {args}       every forwarded arg, each one quoted for the shell
+        {args[0]}    a single forwarded arg, as it was given (use {args[0]|q} to quote it)
+	    "grep": "grep {args} -r {src_dir}"
+    a state value named "args" takes the place of {args}, forwarded args are then appended to the task
+    forwarded args the task does not place are appended to it, like the ones after {args[0]} when it only
+    uses that one, or all of them when {args} is in an {if} section that is not included
+
+    a default value can be given after ":-", it is used when the key is not in state:
+	    "build": "make -j{jobs:-8} CONFIG={config:-debug}"
+
+    literal braces are written by doubling them, "{{" for '{' and "}}" for '}':
+	    "headers": "cp {file}.{{c,h}} {dest}"
+
+    filters after a '|' transform the value before it is inserted, they run left to right:
+        q                 wrap in single quotes, safe for any value in a POSIX shell
+        dq                wrap in double quotes, escaping what the shell would expand inside them
+        raw               insert the value unchanged (the default)
+        lower, upper      change the case of the value
+        trim              remove leading and trailing whitespace
+        replace:old:new   replace every occurrence of old with new
+        basename          last element of a path
+        dirname           everything but the last element of a path
+        abs               absolute path, relative to where stask is run
+	    "open": "ls -l {path|q}"
+	    "checkout": "git checkout -b wip-{branch|replace:/:-|lower}"
+
+    sections between "{if <condition>}" and "{end}" are only included when the condition holds,
+    an optional "{else}" section is included otherwise:
+        {if key}          key is set and not empty
+        {if key=value}    key is set to value
+        {if key!=value}   key is not set to value
+	    "build": "cc{if asan=on} --sanitize=address{end}{if opt} -O{opt}{else} -O0{end} {src}"
+    the key can have filters, the value follows them: {if branch|lower=main}
+    "{else}" and "{end}" are tags, a key with one of these names is inserted with a filter: {end|raw}`
 
 const shellHelptext = `stask shell config:
     stask requires that a shell be explicitely set via environment variables
@@ -109,14 +300,40 @@ const shellHelptext = `stask shell config:
     stask passes flags to the shell to execute your tasks
     by default is passes "-ic" flags, you can customized the passed in flags with this variable:
         STASK_SHELL_FLAGS    custom flags passed to shell
+    the tasks of a parallel group run in a shell that is not interactive, "-i" is left out of the flags,
+    an interactive shell takes over the terminal and would stop the other tasks of the group
+    (only "-i" and POSIX flag clusters like "-ic" or "-lic" are changed, other flags are passed as they are)
 
 	the complete command executed by "stask run" looks like this:
-	    <$STASK_SHELL(or $SHELL)> <$STASK_SHELL_FLAGS> "<task>"`
+	    <$STASK_SHELL(or $SHELL)> <$STASK_SHELL_FLAGS> "<task>"
+
+    tasks can also run without a shell, which avoids the cost of starting it (and of its rc files)
+    the task is split into arguments like a shell would, but state values are never split,
+    and shell features such as pipes, && or $VARIABLES are not available
+    set "exec": "direct" on a task, or set this variable to do it for every task:
+        STASK_EXEC    "direct" or "shell" (the default), tasks setting "exec" take precedence`
+
+const exitHelptext = `stask exit codes:
+    when a task fails, stask exits with the exit code of the task
+    errors of stask itself exit with codes tasks rarely use, so scripts running stask can tell them apart:
+
+        0     success
+        1     "stask lint --strict" found warnings
+        64    usage error: unknown command, wrong arguments, or a task that is not in the staskfile
+        65    a staskfile cannot be used: syntax error, value of the wrong type, newer version,
+              include or dependency not found (also "stask validate" finding problems)
+        66    no staskfile found, run "stask init" to create one
+        67    a task is not a valid template, see "stask help syntax"
+        68    a task uses keys that are not in state or the environment
+        69    the shell or exec mode is not configured, see "stask help shell"
+        70    any other error of stask, such as a staskfile that cannot be written
+        127   the command of a task could not be started (tasks run without a shell)
+        128+n a task was killed by signal n, like 130 for SIGINT (Ctrl-C) and 143 for SIGTERM`
 
 func main() {
 	if len(os.Args) < 2 {
 		fmt.Fprintln(flag.CommandLine.Output(), mainHelptext)
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
 	switch os.Args[1] {
@@ -128,10 +345,10 @@ func main() {
 		doHelp(os.Args)
 
 	case "init":
-		doInit()
+		doInit(os.Args)
 
 	case "state":
-		doState()
+		doState(os.Args)
 
 	case "set":
 		doSet(os.Args)
@@ -154,17 +371,32 @@ func main() {
 	case "staskfile":
 		doStaskfile()
 
+	case "convert":
+		doConvert(os.Args)
+
+	case "upgrade":
+		doUpgrade(os.Args)
+
+	case "validate":
+		doValidate(os.Args)
+
+	case "lint":
+		doLint(os.Args)
+
+	case "schema":
+		doSchema()
+
 	default:
 		fmt.Fprintf(flag.CommandLine.Output(), "error: unexpected command '%s'\n", os.Args[1])
 		fmt.Fprintln(flag.CommandLine.Output(), "    use \"stask --help\" for usage information")
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 }
 
 func doHelp(args []string) {
 	if len(os.Args) < 3 {
 		fmt.Fprintln(flag.CommandLine.Output(), helpHelptext)
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
 	topic := args[2]
@@ -173,6 +405,9 @@ func doHelp(args []string) {
 	case "help":
 		fmt.Fprintln(flag.CommandLine.Output(), helpHelptext)
 
+	case "init":
+		fmt.Fprintln(flag.CommandLine.Output(), helpInit)
+
 	case "state":
 		fmt.Fprintln(flag.CommandLine.Output(), stateHelptext)
 
@@ -197,43 +432,85 @@ func doHelp(args []string) {
 	case "staskfile":
 		fmt.Fprintln(flag.CommandLine.Output(), staskfileHelptext)
 
+	case "convert":
+		fmt.Fprintln(flag.CommandLine.Output(), convertHelptext)
+
+	case "upgrade":
+		fmt.Fprintln(flag.CommandLine.Output(), upgradeHelptext)
+
+	case "validate":
+		fmt.Fprintln(flag.CommandLine.Output(), validateHelptext)
+
+	case "lint":
+		fmt.Fprintln(flag.CommandLine.Output(), lintHelptext)
+
+	case "schema":
+		fmt.Fprintln(flag.CommandLine.Output(), schemaHelptext)
+
 	case "syntax":
 		fmt.Fprintln(flag.CommandLine.Output(), syntaxHelptext)
 
 	case "shell":
 		fmt.Fprintln(flag.CommandLine.Output(), shellHelptext)
 
+	case "exit":
+		fmt.Fprintln(flag.CommandLine.Output(), exitHelptext)
+
 	default:
 		fmt.Fprintf(flag.CommandLine.Output(), "error: unexpected help topic '%s'\n", topic)
 		fmt.Fprintln(flag.CommandLine.Output(), "    use \"stask --help\" for information")
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 }
 
-func doInit() {
+func doInit(args []string) {
 	var staskfilePath = getStaskfilePath()
+	if len(args) > 2 {
+		if args[2] != "--local" || len(args) > 3 {
+			fmt.Fprintln(flag.CommandLine.Output(), "error: unexpected arguments")
+			fmt.Fprintln(flag.CommandLine.Output(), "    use \"stask help init\" for usage information")
+			os.Exit(exitUsage)
+		}
+		cwd, err := os.Getwd()
+		if err != nil {
+			exitWithError(err)
+		}
+		staskfilePath = filepath.Join(cwd, staskfile.ProjectFileNames[0])
+	}
+
 	var staskfileDir = filepath.Dir(staskfilePath)
 	var err = os.MkdirAll(staskfileDir, os.ModePerm)
 	if err != nil {
-		panic(err)
+		exitWithError(err)
 	}
+	defer lockLayer(layerLocation{path: staskfilePath}).Unlock()
 	if _, err := os.Stat(staskfilePath); errors.Is(err, os.ErrNotExist) {
-		staskfile.WriteStaskfile(staskfilePath, staskfile.Staskfile{Tasks: make(map[string]string), State: make(map[string]string)})
+		err := staskfile.WriteStaskfile(staskfilePath, staskfile.Empty())
+		if err != nil {
+			exitWithError(err)
+		}
 		fmt.Fprintln(flag.CommandLine.Output(), "success - wrote default staskfile at path:")
 		fmt.Fprintln(flag.CommandLine.Output(), "    ", staskfilePath)
 	} else {
 		fmt.Fprintln(flag.CommandLine.Output(), "error: staskfile already exists at path:")
 		fmt.Fprintln(flag.CommandLine.Output(), "    ", staskfilePath)
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 }
 
-func doState() {
-	sf, err := staskfile.ReadStaskfile(getStaskfilePath())
-	if err != nil {
-		panic(err)
+func doState(args []string) {
+	resolved := false
+	if len(args) > 2 {
+		if args[2] != "--resolved" || len(args) > 3 {
+			fmt.Fprintln(flag.CommandLine.Output(), "error: unexpected arguments")
+			fmt.Fprintln(flag.CommandLine.Output(), "    use \"stask help state\" for usage information")
+			os.Exit(exitUsage)
+		}
+		resolved = true
 	}
 
+	sf := readStaskfile()
+
 	if len(sf.State) == 0 {
 		fmt.Fprintln(flag.CommandLine.Output(), "no state stored in staskfile")
 		return
@@ -241,48 +518,77 @@ func doState() {
 
 	fmt.Fprintln(os.Stdout, "stask state:")
 	for key, value := range sf.State {
-		fmt.Fprintln(os.Stdout, "    ", key, ":", value)
+		if resolved {
+			resolvedValue, missing, err := template.ResolveValue(key, sf.State)
+			if err != nil {
+				value = fmt.Sprintf("%s [error: %v]", value, err)
+			} else if len(missing) > 0 {
+				value = fmt.Sprintf("%s [keys not found: %v]", value, missing)
+			} else {
+				value = resolvedValue
+			}
+		}
+		fmt.Fprintln(os.Stdout, "    ", key, ":", value+layerSuffix(sf, sf.StateLayers[key]))
 	}
 }
 
 func doSet(args []string) {
-	if len(args) != 4 {
-		fmt.Fprintln(flag.CommandLine.Output(), "error: unexpected number of arguments")
+	exitSetUsageError := func(message string) {
+		fmt.Fprintf(flag.CommandLine.Output(), "error: %s\n", message)
 		fmt.Fprintln(flag.CommandLine.Output(), "    use \"stask help set\" for usage information")
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
-	var key = args[2]
-	var value = args[3]
 
-	sf, err := staskfile.ReadStaskfile(getStaskfilePath())
-	if err != nil {
-		panic(err)
+	layer, args := parseLayerFlag(args[2:], exitSetUsageError)
+	if len(args) != 2 {
+		exitSetUsageError("unexpected number of arguments")
 	}
+	var key = args[0]
+	var value = args[1]
 
+	defer lockLayer(layer).Unlock()
+	sf := readLayer(layer)
 	sf.State[key] = value
-	err = staskfile.WriteStaskfile(getStaskfilePath(), sf)
+	err := updateLayer(layer, sf)
 	if err != nil {
-		panic(err)
+		exitWithError(err)
+	}
+	noteShadowedState(layer, []string{key})
+}
+
+// tells about keys written to the layer that a higher layer also sets, tasks use the value from that layer
+func noteShadowedState(layer layerLocation, keys []string) {
+	stateLayers := readStaskfile().StateLayers
+	for _, key := range keys {
+		if other, found := stateLayers[key]; found && other != layer.name {
+			fmt.Fprintf(flag.CommandLine.Output(), "note: '%s' is also set in the %s layer, which takes precedence\n", key, other)
+		}
 	}
 }
 
 func doClear(args []string) {
-	if len(args) != 3 {
-		fmt.Fprintln(flag.CommandLine.Output(), "error: unexpected number of arguments")
+	exitClearUsageError := func(message string) {
+		fmt.Fprintf(flag.CommandLine.Output(), "error: %s\n", message)
 		fmt.Fprintln(flag.CommandLine.Output(), "    use \"stask help clear\" for usage information")
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
-	var key = args[2]
 
-	sf, err := staskfile.ReadStaskfile(getStaskfilePath())
-	if err != nil {
-		panic(err)
+	layer, args := parseLayerFlag(args[2:], exitClearUsageError)
+	if len(args) != 1 {
+		exitClearUsageError("unexpected number of arguments")
 	}
+	var key = args[0]
 
+	defer lockLayer(layer).Unlock()
+	sf := readLayer(layer)
 	delete(sf.State, key)
-	err = staskfile.WriteStaskfile(getStaskfilePath(), sf)
+	err := updateLayer(layer, sf)
 	if err != nil {
-		panic(err)
+		exitWithError(err)
+	}
+
+	if other, found := readStaskfile().StateLayers[key]; found {
+		fmt.Fprintf(flag.CommandLine.Output(), "note: '%s' is still set in the %s layer\n", key, other)
 	}
 }
 
@@ -290,72 +596,180 @@ func doRun(args []string) {
 	exitRunUsageError := func(message string) {
 		fmt.Fprintf(flag.CommandLine.Output(), "error: %s\n", message)
 		fmt.Fprintln(flag.CommandLine.Output(), "    use \"stask help run\" for usage information")
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
 	if len(args) < 3 {
 		exitRunUsageError("unexpected number of arguments")
 	}
 
-	var key = args[2]
+	key, overrides, fwd := parseRunArgs(args, exitRunUsageError)
+	plan := getFormattedPlan(key, overrides, fwd)
+	for _, task := range plan {
+		// tasks can exist only to group their dependencies
+		if len(task.steps) == 0 && len(task.parallel) == 0 {
+			continue
+		}
+		if len(plan) > 1 {
+			fmt.Fprintf(flag.CommandLine.Output(), "stask: running task '%s'\n", task.name)
+		}
+		if len(task.parallel) > 0 {
+			runParallel(task)
+		} else {
+			runTask(task)
+		}
+	}
+}
 
-	var fwd []string
-	if len(args) > 3 {
-		var doubleDash = args[3]
-		if doubleDash != "--" {
-			exitRunUsageError(fmt.Sprintf("unexpected argument '%s'", doubleDash))
+// runs the steps of a task in order, exiting with the exit code of the first one that fails
+func runTask(task formattedTask) {
+	for i, step := range task.steps {
+		if len(task.steps) > 1 {
+			fmt.Fprintf(flag.CommandLine.Output(), "stask: [%d/%d] %s\n", i+1, len(task.steps), step.String())
 		}
 
-		for i := 4; i < len(args); i++ {
-			fwd = append(fwd, args[i])
+		cmd := newCommand(task, step, true)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		exitCode := runCommand(cmd)
+		if exitCode == 0 {
+			continue
+		}
+		if step.continueOnError {
+			fmt.Fprintf(flag.CommandLine.Output(), "stask: step %d of task '%s' failed with exit code %d, continuing\n", i+1, task.name, exitCode)
+			continue
 		}
+		exitWithError(&taskFailedError{task.name, exitCode})
 	}
-
-	execCommand(getFormattedTask(key, fwd))
 }
 
 func doDryrun(args []string) {
 	exitDryrunUsageError := func(message string) {
 		fmt.Fprintf(flag.CommandLine.Output(), "error: %s\n", message)
 		fmt.Fprintln(flag.CommandLine.Output(), "    use \"stask help dryrun\" for usage information")
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
 	if len(args) < 3 {
 		exitDryrunUsageError("unexpected number of arguments")
 	}
 
+	key, overrides, fwd := parseRunArgs(args, exitDryrunUsageError)
+	plan := getFormattedPlan(key, overrides, fwd)
+	for i, task := range plan {
+		if len(plan) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("# task: %s\n", task.name)
+		}
+		printDryrunTask(task)
+
+		for j, child := range task.parallel {
+			if j > 0 || len(plan) > 1 {
+				fmt.Println()
+			}
+			fmt.Printf("# parallel task: %s\n", child.name)
+			printDryrunTask(child)
+		}
+	}
+
+	if len(overrides) > 0 {
+		fmt.Fprintln(flag.CommandLine.Output(), "\nstate overridden for this run:")
+		for _, name := range sortedKeys(overrides) {
+			fmt.Fprintln(flag.CommandLine.Output(), "    ", name, ":", overrides[name])
+		}
+	}
+}
+
+// parses "<task> [-s key=value]... [key=value]... [-- <fwd args>]" from the args of run and dryrun
+// key=value args right after "--" are returned with the forwarded args, see takeFwdOverrides
+func parseRunArgs(args []string, exitUsageError func(message string)) (string, map[string]string, []string) {
 	var key = args[2]
 
-	var fwd []string
-	if len(args) > 3 {
-		var doubleDash = args[3]
-		if doubleDash != "--" {
-			exitDryrunUsageError(fmt.Sprintf("unexpected argument '%s'", doubleDash))
+	overrides := map[string]string{}
+	addOverride := func(pair string) {
+		name, value, found := strings.Cut(pair, "=")
+		if !found || len(name) == 0 {
+			exitUsageError(fmt.Sprintf("expected key=value, got '%s'", pair))
 		}
+		overrides[name] = value
+	}
+
+	var fwd []string
+	for i := 3; i < len(args); i++ {
+		switch arg := args[i]; {
+
+		case arg == "--":
+			fwd = append(fwd, args[i+1:]...)
+			return key, overrides, fwd
+
+		case arg == "-s" || arg == "--set":
+			if i+1 >= len(args) {
+				exitUsageError(fmt.Sprintf("missing key=value after '%s'", arg))
+			}
+			i += 1
+			addOverride(args[i])
 
-		for i := 4; i < len(args); i++ {
-			fwd = append(fwd, args[i])
+		case strings.HasPrefix(arg, "--set="):
+			addOverride(strings.TrimPrefix(arg, "--set="))
+
+		case strings.Contains(arg, "=") && !strings.HasPrefix(arg, "-"):
+			addOverride(arg)
+
+		default:
+			exitUsageError(fmt.Sprintf("unexpected argument '%s'", arg))
 		}
 	}
+	return key, overrides, fwd
+}
+
+func printDryrunTask(task formattedTask) {
+	for _, step := range task.steps {
+		fmt.Println(step.String())
+	}
 
-	fmt.Println(getFormattedTask(key, fwd))
+	if len(task.cwd) > 0 {
+		fmt.Fprintln(flag.CommandLine.Output(), "\nruns in:", task.cwd)
+	}
+	if len(task.env) > 0 {
+		fmt.Fprintln(flag.CommandLine.Output(), "\nwith environment:")
+		for _, name := range sortedKeys(task.env) {
+			fmt.Fprintln(flag.CommandLine.Output(), "    ", name+"="+task.env[name])
+		}
+	}
+	if len(task.defaulted) > 0 {
+		fmt.Fprintln(flag.CommandLine.Output(), "\nkeys not found, using default values:")
+		for _, key := range task.defaulted {
+			fmt.Fprintln(flag.CommandLine.Output(), "    ", key.Name(), ":", key.Default)
+		}
+	}
 }
 
 func doTasks() {
-	sf, err := staskfile.ReadStaskfile(getStaskfilePath())
-	if err != nil {
-		panic(err)
-	}
+	sf := readStaskfile()
 
 	if len(sf.Tasks) == 0 {
 		fmt.Fprintln(flag.CommandLine.Output(), "no tasks found in staskfile")
 		return
 	}
 
-	fmt.Fprintln(os.Stdout, "stask tasks:")
+	width := 0
 	for key := range sf.Tasks {
-		fmt.Fprintln(os.Stdout, "    ", key)
+		if len(key) > width {
+			width = len(key)
+		}
+	}
+
+	fmt.Fprintln(os.Stdout, "stask tasks:")
+	for _, key := range sortedKeys(sf.Tasks) {
+		suffix := layerSuffix(sf, sf.TaskLayers[key])
+		if desc := sf.Tasks[key].Desc; len(desc) > 0 {
+			fmt.Fprintf(os.Stdout, "     %-*s   %s%s\n", width, key, desc, suffix)
+		} else {
+			fmt.Fprintln(os.Stdout, "    ", key+suffix)
+		}
 	}
 }
 
@@ -363,7 +777,7 @@ func doProfile(args []string) {
 	exitProfileUsageError := func(message string) {
 		fmt.Fprintf(flag.CommandLine.Output(), "error: %s\n", message)
 		fmt.Fprintln(flag.CommandLine.Output(), "    use \"stask help profile\" for usage information")
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
 	if len(os.Args) < 3 {
@@ -383,22 +797,25 @@ func doProfile(args []string) {
 		doProfileShow(os.Args[3])
 
 	case "load":
-		if len(os.Args) < 4 {
+		layer, rest := parseLayerFlag(args[3:], exitProfileUsageError)
+		if len(rest) < 1 {
 			exitProfileUsageError("missing argument <name>")
 		}
-		doProfileLoad(os.Args[3])
+		doProfileLoad(rest[0], layer)
 
 	case "save":
-		if len(os.Args) < 4 {
+		layer, rest := parseLayerFlag(args[3:], exitProfileUsageError)
+		if len(rest) < 1 {
 			exitProfileUsageError("missing argument <name>")
 		}
-		doProfileSave(os.Args[3])
+		doProfileSave(rest[0], layer)
 
 	case "delete":
-		if len(os.Args) < 4 {
+		layer, rest := parseLayerFlag(args[3:], exitProfileUsageError)
+		if len(rest) < 1 {
 			exitProfileUsageError("missing argument <name>")
 		}
-		doProfileDelete(os.Args[3])
+		doProfileDelete(rest[0], layer)
 
 	default:
 		exitProfileUsageError(fmt.Sprintf("unexpected subcommand '%s'", subcommand))
@@ -406,10 +823,7 @@ func doProfile(args []string) {
 }
 
 func doProfileList() {
-	sf, err := staskfile.ReadStaskfile(getStaskfilePath())
-	if err != nil {
-		panic(err)
-	}
+	sf := readStaskfile()
 
 	if len(sf.Profiles) == 0 {
 		fmt.Fprintln(flag.CommandLine.Output(), "no profiles stored in staskfile")
@@ -418,15 +832,12 @@ func doProfileList() {
 
 	fmt.Fprintln(os.Stdout, "saved profiles:")
 	for key := range sf.Profiles {
-		fmt.Fprintln(os.Stdout, "    ", key)
+		fmt.Fprintln(os.Stdout, "    ", key+layerSuffix(sf, sf.ProfileLayers[key]))
 	}
 }
 
 func doProfileShow(name string) {
-	sf, err := staskfile.ReadStaskfile(getStaskfilePath())
-	if err != nil {
-		panic(err)
-	}
+	sf := readStaskfile()
 
 	profile, found := sf.Profiles[name]
 	if !found {
@@ -440,18 +851,16 @@ func doProfileShow(name string) {
 	}
 }
 
-func doProfileLoad(name string) {
-	sf, err := staskfile.ReadStaskfile(getStaskfilePath())
-	if err != nil {
-		panic(err)
-	}
-
-	profile, found := sf.Profiles[name]
+// profiles can come from any layer, their state is written to the given layer
+func doProfileLoad(name string, layer layerLocation) {
+	profile, found := readStaskfile().Profiles[name]
 	if !found {
 		fmt.Fprintf(flag.CommandLine.Output(), "no profile named '%s' in staskfile\n", name)
 		return
 	}
 
+	defer lockLayer(layer).Unlock()
+	sf := readLayer(layer)
 	fmt.Fprintf(os.Stdout, "%s - applying profile...\n", name)
 	for key, value := range profile {
 		currentValue, found := sf.State[key]
@@ -467,34 +876,31 @@ func doProfileLoad(name string) {
 		}
 	}
 
-	err = staskfile.WriteStaskfile(getStaskfilePath(), sf)
+	err := updateLayer(layer, sf)
 	if err != nil {
-		fmt.Println("error while writing staskfile, profile was not applied")
-		panic(err)
+		exitWithError(fmt.Errorf("could not write staskfile, profile was not applied: %w", err))
 	}
 
 	fmt.Println("\nprofile applied sucessfully")
+	noteShadowedState(layer, sortedKeys(profile))
 }
 
-func doProfileSave(name string) {
-	sf, err := staskfile.ReadStaskfile(getStaskfilePath())
-	if err != nil {
-		panic(err)
-	}
-
+// the profile holds the state of every layer merged together, it is written to the given layer
+func doProfileSave(name string, layer layerLocation) {
+	defer lockLayer(layer).Unlock()
+	sf := readLayer(layer)
 	_, profileExists := sf.Profiles[name]
 
 	newProfile := map[string]string{}
-	for key, value := range sf.State {
+	for key, value := range readStaskfile().State {
 		newProfile[key] = value
 	}
 
 	sf.Profiles[name] = newProfile
 
-	err = staskfile.WriteStaskfile(getStaskfilePath(), sf)
+	err := updateLayer(layer, sf)
 	if err != nil {
-		fmt.Println("error while writing staskfile, profile was not applied")
-		panic(err)
+		exitWithError(fmt.Errorf("could not write staskfile, profile was not saved: %w", err))
 	}
 
 	if profileExists {
@@ -502,47 +908,371 @@ func doProfileSave(name string) {
 	} else {
 		fmt.Fprintf(os.Stdout, "profile '%s' saved sucessfully\n", name)
 	}
-}
-
-func doProfileDelete(name string) {
-	sf, err := staskfile.ReadStaskfile(getStaskfilePath())
-	if err != nil {
-		panic(err)
+	// profiles are not merged, the one from the highest layer is used whole
+	if other := readStaskfile().ProfileLayers[name]; other != layer.name {
+		fmt.Fprintf(flag.CommandLine.Output(), "note: profile '%s' is also saved in the %s layer, which takes precedence\n", name, other)
 	}
+}
 
+func doProfileDelete(name string, layer layerLocation) {
+	defer lockLayer(layer).Unlock()
+	sf := readLayer(layer)
 	_, profileExists := sf.Profiles[name]
 	if !profileExists {
-		fmt.Fprintf(flag.CommandLine.Output(), "no profile named '%s' in staskfile\n", name)
+		fmt.Fprintf(flag.CommandLine.Output(), "no profile named '%s' in the %s layer\n", name, layer.name)
+		if other, found := readStaskfile().ProfileLayers[name]; found {
+			fmt.Fprintf(flag.CommandLine.Output(), "    it is in the %s layer, use \"--layer %s\" to delete it\n", other, other)
+		}
 		return
 	}
 
 	delete(sf.Profiles, name)
 
-	err = staskfile.WriteStaskfile(getStaskfilePath(), sf)
+	err := updateLayer(layer, sf)
 	if err != nil {
-		fmt.Println("error while writing staskfile, profile was not applied")
-		panic(err)
+		exitWithError(fmt.Errorf("could not write staskfile, profile was not deleted: %w", err))
 	}
 
 	fmt.Fprintf(os.Stdout, "profile '%s' deleted sucessfully\n", name)
 }
 
 func doStaskfile() {
-	fmt.Fprintln(os.Stdout, getStaskfilePath())
+	locations := getLayerLocations()
+	location := getDefaultLayer(locations)
+	fmt.Fprintln(os.Stdout, location.path)
+	fmt.Fprintln(flag.CommandLine.Output(), "    ", location.reason)
+	if len(locations) == 1 {
+		return
+	}
+
+	fmt.Fprintln(flag.CommandLine.Output(), "\nlayers, merged from lowest to highest precedence:")
+	for _, location := range locations {
+		path := location.path
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			path += " (not found)"
+		}
+		fmt.Fprintf(flag.CommandLine.Output(), "     %-7s   %s\n", location.name, path)
+	}
+}
+
+func doConvert(args []string) {
+	exitConvertUsageError := func(message string) {
+		fmt.Fprintf(flag.CommandLine.Output(), "error: %s\n", message)
+		fmt.Fprintln(flag.CommandLine.Output(), "    use \"stask help convert\" for usage information")
+		os.Exit(exitUsage)
+	}
+
+	layer, args := parseLayerFlag(args[2:], exitConvertUsageError)
+	if len(args) != 1 {
+		exitConvertUsageError("unexpected number of arguments")
+	}
+	format := strings.ToLower(args[0])
+	if format == "yml" {
+		format = staskfile.FormatYAML
+	}
+	if format != staskfile.FormatJSON && format != staskfile.FormatYAML && format != staskfile.FormatTOML {
+		exitConvertUsageError(fmt.Sprintf("unknown format '%s', expected \"%s\", \"%s\" or \"%s\"", args[0], staskfile.FormatJSON, staskfile.FormatYAML, staskfile.FormatTOML))
+	}
+
+	if staskfile.FormatOf(layer.path) == format {
+		fmt.Fprintf(flag.CommandLine.Output(), "staskfile is already written in %s:\n", format)
+		fmt.Fprintln(flag.CommandLine.Output(), "    ", layer.path)
+		return
+	}
+
+	if _, err := os.Stat(layer.path); errors.Is(err, os.ErrNotExist) {
+		exitWithError(&notFoundError{layer.path})
+	}
+	lock := lockLayer(layer)
+	sf := readLayer(layer)
+
+	newPath := strings.TrimSuffix(layer.path, filepath.Ext(layer.path)) + staskfile.Extension(format)
+	if _, err := os.Stat(newPath); err == nil {
+		fmt.Fprintln(flag.CommandLine.Output(), "error: a file already exists at path:")
+		fmt.Fprintln(flag.CommandLine.Output(), "    ", newPath)
+		os.Exit(exitUsage)
+	}
+	err := staskfile.WriteStaskfile(newPath, sf)
+	if err != nil {
+		exitWithError(err)
+	}
+	err = os.Remove(layer.path)
+	if err != nil {
+		exitWithError(err)
+	}
+	// the lock file is kept, a command waiting on it would take a lock nobody else sees if it was removed
+	lock.Unlock()
+
+	fmt.Fprintln(flag.CommandLine.Output(), "success - converted staskfile to", format)
+	fmt.Fprintln(flag.CommandLine.Output(), "    ", layer.path, "->", newPath)
+	if _, found := os.LookupEnv("STASKFILE_PATH"); found {
+		fmt.Fprintln(flag.CommandLine.Output(), "    update STASKFILE_PATH to use the new path")
+	}
+}
+
+func doUpgrade(args []string) {
+	exitUpgradeUsageError := func(message string) {
+		fmt.Fprintf(flag.CommandLine.Output(), "error: %s\n", message)
+		fmt.Fprintln(flag.CommandLine.Output(), "    use \"stask help upgrade\" for usage information")
+		os.Exit(exitUsage)
+	}
+
+	layer, args := parseLayerFlag(args[2:], exitUpgradeUsageError)
+	if len(args) != 0 {
+		exitUpgradeUsageError("unexpected number of arguments")
+	}
+	if _, err := os.Stat(layer.path); errors.Is(err, os.ErrNotExist) {
+		exitWithError(&notFoundError{layer.path})
+	}
+
+	defer lockLayer(layer).Unlock()
+	version, err := staskfile.UpgradeStaskfile(layer.path)
+	if err != nil {
+		var parseErr *staskfile.ParseError
+		var versionErr *staskfile.VersionError
+		if errors.As(err, &parseErr) || errors.As(err, &versionErr) {
+			exitWithError(&invalidError{err})
+		}
+		exitWithError(err)
+	}
+	if version == staskfile.CurrentVersion {
+		fmt.Fprintf(flag.CommandLine.Output(), "staskfile is already version %d:\n", version)
+		fmt.Fprintln(flag.CommandLine.Output(), "    ", layer.path)
+		return
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "success - upgraded staskfile from version %d to %d\n", version, staskfile.CurrentVersion)
+	fmt.Fprintln(flag.CommandLine.Output(), "    ", layer.path)
+	fmt.Fprintln(flag.CommandLine.Output(), "    the original is kept as", staskfile.BackupPath(layer.path, version))
+}
+
+func doValidate(args []string) {
+	if len(args) > 3 {
+		fmt.Fprintln(flag.CommandLine.Output(), "error: unexpected number of arguments")
+		fmt.Fprintln(flag.CommandLine.Output(), "    use \"stask help validate\" for usage information")
+		os.Exit(exitUsage)
+	}
+	path := getStaskfilePath()
+	if len(args) == 3 {
+		path = args[2]
+	}
+
+	problems, err := staskfile.Validate(path)
+	if errors.Is(err, os.ErrNotExist) {
+		exitWithError(&notFoundError{path})
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if len(problems) == 0 {
+		fmt.Fprintln(flag.CommandLine.Output(), "success - no problems found in staskfile:")
+		fmt.Fprintln(flag.CommandLine.Output(), "    ", path)
+		return
+	}
+	fmt.Fprintf(os.Stdout, "%d problem(s) found in staskfile %s:\n", len(problems), path)
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stdout, "    ", problem)
+	}
+	os.Exit(exitInvalid)
+}
+
+func doLint(args []string) {
+	strict := os.Getenv("STASK_LINT_STRICT") == "1"
+	for _, arg := range args[2:] {
+		if arg != "--strict" {
+			fmt.Fprintf(flag.CommandLine.Output(), "error: unexpected argument '%s'\n", arg)
+			fmt.Fprintln(flag.CommandLine.Output(), "    use \"stask help lint\" for usage information")
+			os.Exit(exitUsage)
+		}
+		strict = true
+	}
+
+	sf := readStaskfile()
+	warnings := sf.Lint(getExecMode(staskfile.Task{}))
+	if len(warnings) == 0 {
+		fmt.Fprintln(flag.CommandLine.Output(), "success - no warnings")
+		return
+	}
+	fmt.Fprintf(os.Stdout, "%d warning(s) found:\n", len(warnings))
+	for _, warning := range warnings {
+		fmt.Fprintln(os.Stdout, "    ", warning)
+	}
+	if strict {
+		os.Exit(exitLintWarnings)
+	}
+}
+
+func doSchema() {
+	schema, err := staskfile.Schema()
+	if err != nil {
+		exitWithError(err)
+	}
+	fmt.Fprintln(os.Stdout, string(schema))
 }
 
 func getStaskfilePath() string {
+	return getDefaultLayer(getLayerLocations()).path
+}
+
+// a staskfile merged with the others, see getLayerLocations
+type layerLocation struct {
+	name string
+	path string
+	// why this staskfile is used
+	reason string
+}
+
+// returns the staskfiles to merge from lowest to highest precedence, whether they exist or not
+// the global staskfile comes first, then a project staskfile in the current directory or its parents up to home,
+// then the local staskfile next to it, STASKFILE_PATH replaces all of them
+func getLayerLocations() []layerLocation {
 	path, _ := os.LookupEnv("STASKFILE_PATH")
 	if len(path) > 0 {
-		return path
+		return []layerLocation{{staskfile.LayerGlobal, path, "set by the STASKFILE_PATH environment variable"}}
 	}
 
 	homedir, err := os.UserHomeDir()
 	if err != nil {
-		panic(err)
+		exitWithError(err)
+	}
+	globalDir := filepath.Join(homedir, ".config", "stask")
+	globalPath, found := staskfile.FindStaskfile(globalDir, "staskfile")
+	if !found {
+		globalPath = filepath.Join(globalDir, "staskfile.json")
 	}
 
-	return filepath.Join(homedir, ".config", "stask", "staskfile.json")
+	cwd, err := os.Getwd()
+	if err != nil {
+		exitWithError(err)
+	}
+	path, found = staskfile.FindProjectStaskfile(cwd, globalPath)
+	if !found {
+		return []layerLocation{{staskfile.LayerGlobal, globalPath, "global staskfile, no project staskfile found"}}
+	}
+
+	return []layerLocation{
+		{staskfile.LayerGlobal, globalPath, "global staskfile"},
+		{staskfile.LayerProject, path, fmt.Sprintf("project staskfile, found searching up from %s", cwd)},
+		{staskfile.LayerLocal, staskfile.LocalPath(path), "local staskfile, next to the project staskfile"},
+	}
+}
+
+// the layer written to when none is selected: the project staskfile if there is one, the global one otherwise
+func getDefaultLayer(locations []layerLocation) layerLocation {
+	for _, location := range locations {
+		if location.name == staskfile.LayerProject {
+			return location
+		}
+	}
+	return locations[0]
+}
+
+// removes "--layer <name>" from the args and returns the layer it selects, or the default layer without it
+func parseLayerFlag(args []string, exitUsageError func(message string)) (layerLocation, []string) {
+	name := ""
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if value, found := strings.CutPrefix(arg, "--layer="); found {
+			name = value
+		} else if arg == "--layer" {
+			if i+1 >= len(args) {
+				exitUsageError("missing layer name after '--layer'")
+			}
+			i += 1
+			name = args[i]
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+
+	locations := getLayerLocations()
+	if len(name) == 0 {
+		return getDefaultLayer(locations), rest
+	}
+	if name != staskfile.LayerGlobal && name != staskfile.LayerProject && name != staskfile.LayerLocal {
+		exitUsageError(fmt.Sprintf("unknown layer '%s', expected \"%s\", \"%s\" or \"%s\"", name, staskfile.LayerGlobal, staskfile.LayerProject, staskfile.LayerLocal))
+	}
+
+	for _, location := range locations {
+		if location.name == name {
+			return location, rest
+		}
+	}
+	if _, found := os.LookupEnv("STASKFILE_PATH"); found {
+		exitUsageError(fmt.Sprintf("layer '%s' is not used when STASKFILE_PATH is set", name))
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "error: layer '%s' needs a project staskfile, none was found\n", name)
+	fmt.Fprintln(flag.CommandLine.Output(), "    use \"stask init --local\" to create one in the current directory")
+	os.Exit(exitNotFound)
+	return layerLocation{}, nil
+}
+
+// reads and merges every layer that exists, only the default layer is required to exist
+func readStaskfile() staskfile.Merged {
+	locations := getLayerLocations()
+	required := getDefaultLayer(locations)
+
+	var layers []staskfile.Layer
+	for _, location := range locations {
+		// checked first, a missing included staskfile must not be mistaken for a missing layer
+		if _, err := os.Stat(location.path); errors.Is(err, os.ErrNotExist) {
+			if location != required {
+				continue
+			}
+			exitWithError(&notFoundError{location.path})
+		}
+		sf, err := staskfile.ReadStaskfile(location.path)
+		if err != nil {
+			exitWithError(&invalidError{err})
+		}
+		layers = append(layers, staskfile.Layer{Name: location.name, Path: location.path, Staskfile: sf})
+	}
+	return staskfile.Merge(layers)
+}
+
+// reads a single layer to change it, a missing local staskfile is created when the layer is written
+// its includes are not resolved, they are written back as they are
+func readLayer(location layerLocation) staskfile.Staskfile {
+	sf, err := staskfile.ReadStaskfileNoIncludes(location.path)
+	if errors.Is(err, os.ErrNotExist) {
+		if location.name == staskfile.LayerLocal {
+			return staskfile.Empty()
+		}
+		exitWithError(&notFoundError{location.path})
+	}
+	if err != nil {
+		exitWithError(&invalidError{err})
+	}
+	return sf
+}
+
+// writes the state and profiles of a layer back to its staskfile, a document that cannot be edited
+// without losing what it has written is not usable by the command
+func updateLayer(location layerLocation, sf staskfile.Staskfile) error {
+	err := staskfile.UpdateStaskfile(location.path, sf)
+	if errors.Is(err, staskfile.ErrNotEditable) {
+		return &invalidError{err}
+	}
+	return err
+}
+
+// locks the staskfile of a layer while it is read, changed and written back, so stask commands run at the same time
+// do not lose each other's changes
+func lockLayer(location layerLocation) *staskfile.Lock {
+	lock, err := staskfile.LockStaskfile(location.path)
+	if err != nil {
+		exitWithError(err)
+	}
+	return lock
+}
+
+// returns " [layer]" when entries can come from more than one layer, so output can show where each one is from
+func layerSuffix(sf staskfile.Merged, layer string) string {
+	if len(sf.Layers) < 2 {
+		return ""
+	}
+	return " [" + layer + "]"
 }
 
 func getShellConfig() (shellConfig struct {
@@ -554,9 +1284,7 @@ func getShellConfig() (shellConfig struct {
 	if len(shell) == 0 {
 		envShell, prs := os.LookupEnv("SHELL")
 		if len(envShell) == 0 || !prs {
-			fmt.Fprintln(flag.CommandLine.Output(), "error: no shell set")
-			fmt.Fprintln(flag.CommandLine.Output(), "    set either STASK_SHELL or SHELL env vars with path to shell STASK should use")
-			os.Exit(1)
+			exitWithError(&shellError{"no shell set, set either STASK_SHELL or SHELL env vars with path to shell STASK should use"})
 		}
 		shell = envShell
 	}
@@ -571,59 +1299,358 @@ func getShellConfig() (shellConfig struct {
 	return shellConfig
 }
 
-func readStaskfile() staskfile.Staskfile {
-	return staskfile.Staskfile{}
+// a task with state applied, ready to be executed
+type formattedTask struct {
+	name  string
+	steps []formattedStep
+	cwd   string
+	env   map[string]string
+	// run steps without a shell, using their args
+	direct bool
+	// tasks run at the same time instead of steps
+	parallel []formattedTask
+	failFast bool
+	// keys that were filled in with their default value
+	defaulted []template.Key
 }
 
-func getFormattedTask(key string, fwd []string) string {
-	sf, err := staskfile.ReadStaskfile(getStaskfilePath())
+type formattedStep struct {
+	command string
+	// the command split into arguments, only set for tasks that run without a shell
+	args            []string
+	continueOnError bool
+}
+
+func (step formattedStep) String() string {
+	if step.args == nil {
+		return step.command
+	}
+	quoted := make([]string, len(step.args))
+	for i, arg := range step.args {
+		quoted[i] = template.ShellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// returns the task and all of its dependencies with state applied, in the order they should run
+// overrides take precedence over stored state for this run only, forwarded args are only applied to the requested task
+func getFormattedPlan(key string, overrides map[string]string, fwd []string) []formattedTask {
+	sf := readStaskfile().Staskfile
+
+	if _, found := sf.Tasks[key]; !found {
+		fmt.Fprintf(flag.CommandLine.Output(), "error: task '%s' was not found in staskfile\n", key)
+		fmt.Fprintln(flag.CommandLine.Output(), "    use \"stask tasks\" to list the tasks")
+		os.Exit(exitUsage)
+	}
+	plan, err := sf.Plan(key)
 	if err != nil {
-		panic(err)
+		exitWithError(&invalidError{err})
+	}
+
+	// the staskfile is never written back, so overrides can be layered on its state
+	fwd = takeFwdOverrides(sf, plan, fwd, overrides)
+	for name, value := range overrides {
+		sf.State[name] = value
 	}
 
-	task, prs := sf.Tasks[key]
-	if !prs {
-		fmt.Fprintln(flag.CommandLine.Output(), "error: task '", key, "' was not found in staskfile")
-		os.Exit(1)
+	if len(fwd) > 0 && len(sf.Tasks[key].Parallel) > 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "error: args cannot be forwarded to parallel group '%s'\n", key)
+		os.Exit(exitUsage)
 	}
 
-	tmpl, err := template.ParseTemplate(task)
+	planned := map[string]bool{}
+	for _, name := range plan {
+		planned[name] = true
+	}
+
+	var formatted []formattedTask
+	for _, name := range plan {
+		if name == key {
+			formatted = append(formatted, getFormattedTask(sf, name, fwd))
+		} else {
+			formatted = append(formatted, getFormattedTask(sf, name, nil))
+		}
+
+		// parallel tasks that already ran as a dependency are not run again
+		group := &formatted[len(formatted)-1]
+		var children []formattedTask
+		for _, child := range group.parallel {
+			if !planned[child.name] {
+				children = append(children, child)
+			}
+		}
+		group.parallel = children
+	}
+	return formatted
+}
+
+// moves the key=value args at the start of the forwarded args to overrides, when the planned tasks use the key
+// or it is in state, e.g. "stask run deploy -- env=staging", and returns the args left to forward
+// other key=value args are forwarded, like "stask run make -- CC=clang" to a task that does not use {CC}
+func takeFwdOverrides(sf staskfile.Staskfile, plan []string, fwd []string, overrides map[string]string) []string {
+	used := map[string]bool{}
+	useKeys := func(tmpl template.Template, err error) {
+		if err != nil {
+			return
+		}
+		for _, key := range append(tmpl.Keys, tmpl.CondKeys...) {
+			if len(key.Namespace) == 0 && !key.IsAllArgs() {
+				used[key.Str] = true
+			}
+		}
+	}
+	var useTask func(name string)
+	useTask = func(name string) {
+		task := sf.Tasks[name]
+		for _, str := range task.Templates() {
+			useKeys(template.ParseTemplate(str))
+		}
+		for _, child := range task.Parallel {
+			useTask(child)
+		}
+	}
+	for _, name := range plan {
+		useTask(name)
+	}
+	for name, value := range sf.State {
+		used[name] = true
+		useKeys(template.ParseTemplate(value))
+	}
+
+	for len(fwd) > 0 {
+		name, value, found := strings.Cut(fwd[0], "=")
+		if !found || !used[name] {
+			break
+		}
+		overrides[name] = value
+		fwd = fwd[1:]
+	}
+	return fwd
+}
+
+func getFormattedTask(sf staskfile.Staskfile, key string, fwd []string) formattedTask {
+	task := sf.Tasks[key]
+
+	formatted := formattedTask{name: key, direct: getExecMode(task) == staskfile.ExecDirect}
+	state := template.WithArgs(sf.State, fwd)
+	// forwarded args placed in the cwd or env of the task are not appended to its command either
+	strs := []string{task.Cwd}
+	for _, step := range task.Commands() {
+		strs = append(strs, step.Cmd)
+	}
+	for _, name := range sortedKeys(task.Env) {
+		strs = append(strs, task.Env[name])
+	}
+	allPlaced := false
+	placed := map[int]bool{}
+	for _, str := range strs {
+		all, indexes := template.PlacedArgs(parseTaskTemplate(key, str), state)
+		allPlaced = allPlaced || all
+		for _, index := range indexes {
+			placed[index] = true
+		}
+	}
+	var unplaced []string
+	for i, arg := range fwd {
+		if !allPlaced && !placed[i] {
+			unplaced = append(unplaced, arg)
+		}
+	}
+
+	for _, step := range task.Commands() {
+		formattedStep := formattedStep{continueOnError: step.ContinueOnError}
+		if formatted.direct {
+			formattedStep.args = applyStateArgs(key, step.Cmd, state, &formatted.defaulted)
+		} else {
+			formattedStep.command = applyState(key, step.Cmd, state, &formatted.defaulted)
+		}
+		formatted.steps = append(formatted.steps, formattedStep)
+	}
+
+	// forwarded args the task does not place with {args} or {args[i]} are given to its last step,
+	// as if the steps were chained with &&
+	if len(unplaced) > 0 && len(formatted.steps) > 0 {
+		last := &formatted.steps[len(formatted.steps)-1]
+		if formatted.direct {
+			last.args = append(last.args, unplaced...)
+		} else {
+			last.command += " " + template.QuoteArgs(unplaced)
+		}
+	}
+
+	for _, child := range task.Parallel {
+		formatted.parallel = append(formatted.parallel, getFormattedTask(sf, child, nil))
+	}
+	formatted.failFast = task.FailFast
+
+	if len(task.Cwd) > 0 {
+		formatted.cwd = applyState(key, task.Cwd, state, &formatted.defaulted)
+		if len(task.Dir) > 0 && !filepath.IsAbs(formatted.cwd) {
+			formatted.cwd = filepath.Join(task.Dir, formatted.cwd)
+		}
+	}
+	if len(task.Env) > 0 {
+		formatted.env = map[string]string{}
+		for name, value := range task.Env {
+			formatted.env[name] = applyState(key, value, state, &formatted.defaulted)
+		}
+	}
+
+	return formatted
+}
+
+// applies state to a string of a task, exiting with an error if the string cannot be formatted
+// keys filled in with their default value are appended to defaulted
+func applyState(task string, str string, state map[string]string, defaulted *[]template.Key) string {
+	tmpl := parseTaskTemplate(task, str)
+	formatted, missing, err := template.ApplyTemplate(tmpl, state)
+	exitIfNotApplied(task, missing, err)
+
+	*defaulted = append(*defaulted, template.DefaultedKeys(tmpl, state)...)
+	return formatted
+}
+
+// like applyState, but splits the result into arguments, keeping inserted values whole
+func applyStateArgs(task string, str string, state map[string]string, defaulted *[]template.Key) []string {
+	tmpl := parseTaskTemplate(task, str)
+	args, missing, err := template.ApplyTemplateArgs(tmpl, state)
+	exitIfNotApplied(task, missing, err)
+	if len(args) == 0 {
+		exitWithError(&templateError{task, fmt.Errorf("command '%s' is empty", str)})
+	}
+
+	*defaulted = append(*defaulted, template.DefaultedKeys(tmpl, state)...)
+	return args
+}
+
+func parseTaskTemplate(task string, str string) template.Template {
+	tmpl, err := template.ParseTemplate(str)
+	var parseErr *template.ParseError
+	if errors.As(err, &parseErr) {
+		parseErr.Task = task
+	}
 	if err != nil {
-		panic(err)
+		exitWithError(&templateError{task, err})
 	}
+	return tmpl
+}
 
-	str, missing := template.ApplyTemplate(tmpl, sf.State)
+func exitIfNotApplied(task string, missing []string, err error) {
+	if err != nil {
+		exitWithError(&templateError{task, err})
+	}
 	if len(missing) > 0 {
-		fmt.Fprintln(flag.CommandLine.Output(), "error: task keys not found in state: ", missing)
-		os.Exit(1)
+		exitWithError(&missingStateError{task, missing})
 	}
+}
 
-	if len(fwd) > 0 {
-		str = strings.Join(append([]string{str}, fwd...), " ")
+// returns the exec mode of the task, falling back to STASK_EXEC and then to running in the shell
+func getExecMode(task staskfile.Task) string {
+	if len(task.Exec) > 0 {
+		return task.Exec
 	}
 
-	return str
+	mode, _ := os.LookupEnv("STASK_EXEC")
+	switch mode {
+	case "":
+		return staskfile.ExecShell
+	case staskfile.ExecShell, staskfile.ExecDirect:
+		return mode
+	default:
+		exitWithError(&shellError{fmt.Sprintf("STASK_EXEC is set to unknown exec mode '%s', expected \"%s\" or \"%s\"", mode, staskfile.ExecShell, staskfile.ExecDirect)})
+	}
+	return ""
 }
 
-func execCommand(command string) {
-	shellConfig := getShellConfig()
-	command = shellConfig.flags + " \"" + command + "\""
-	args, err := shlex.Split(command)
-	if err != nil {
-		panic(err)
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// returns a command that runs a step of a task, in the configured shell unless the task runs without one
+// the shell is not interactive when interactive is false, even if STASK_SHELL_FLAGS asks for it
+func newCommand(task formattedTask, step formattedStep, interactive bool) *exec.Cmd {
+	var cmd *exec.Cmd
+	if task.direct {
+		cmd = exec.Command(step.args[0], step.args[1:]...)
+	} else {
+		shellConfig := getShellConfig()
+		args, err := shlex.Split(shellConfig.flags)
+		if err != nil {
+			exitWithError(&shellError{fmt.Sprintf("STASK_SHELL_FLAGS cannot be split into flags: %v", err)})
+		}
+		if !interactive {
+			args = withoutInteractiveFlag(args)
+		}
+		// the command is passed as a single argument so the shell sees it exactly as templated
+		args = append(args, step.command)
+		cmd = exec.Command(shellConfig.shell, args...)
 	}
 
-	cmd := exec.Command(shellConfig.shell, args...)
+	cmd.Dir = task.cwd
 	cmd.Env = os.Environ()
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
+	for _, name := range sortedKeys(task.env) {
+		cmd.Env = append(cmd.Env, name+"="+task.env[name])
+	}
+	return cmd
+}
+
+// removes the interactive flag from shell flags, e.g. "-ic" becomes "-c"
+// only "-i" and clusters of single-letter POSIX shell flags ending with the -c that takes the command are changed,
+// flags of other shells like PowerShell's -NoProfile are kept as they are
+func withoutInteractiveFlag(flags []string) []string {
+	var kept []string
+	for _, flag := range flags {
+		if flag == "--interactive" || flag == "-i" {
+			continue
+		}
+		if isPosixCommandFlags(flag) {
+			flag = strings.ReplaceAll(flag, "i", "")
+		}
+		kept = append(kept, flag)
+	}
+	return kept
+}
+
+func isPosixCommandFlags(flag string) bool {
+	letters, found := strings.CutPrefix(flag, "-")
+	if !found || !strings.HasSuffix(letters, "c") {
+		return false
+	}
+	for _, letter := range letters {
+		if !unicode.IsLetter(letter) || strings.Count(letters, string(letter)) > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// runs a command to completion and returns its exit code
+func runCommand(cmd *exec.Cmd) int {
+	return waitCommand(cmd, cmd.Start())
+}
+
+// waits for a started command and returns its exit code, startErr is the error returned by cmd.Start
+func waitCommand(cmd *exec.Cmd, startErr error) int {
+	err := startErr
+	if err == nil {
+		err = cmd.Wait()
+	}
 	if err != nil {
-		if exerr := err.(*exec.ExitError); exerr != nil {
-			os.Exit(exerr.ExitCode())
+		var exerr *exec.ExitError
+		if errors.As(err, &exerr) {
+			if signal, ok := exitSignal(exerr); ok {
+				return exitSignalBase + signal
+			}
+			return exerr.ExitCode()
 		}
+		// like shells do for commands they cannot run
 		fmt.Fprintln(flag.CommandLine.Output(), "stask error while running task:  ", err.Error())
-		os.Exit(1)
+		return exitCannotRun
 	}
+	return 0
 }
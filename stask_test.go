@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/itsfrank/stask/internal/staskfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	// tests run stask by running their own binary with this set
+	if os.Getenv("STASK_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestTaskKilledBySignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows processes are not killed by signals")
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not installed")
+	}
+
+	path := filepath.Join(t.TempDir(), "staskfile.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"Version": 1, "Tasks": {"die": "kill -TERM $$"}}`), 0644))
+
+	// like a shell, 128 plus the signal, an interactive shell would ignore SIGTERM
+	code, out := runStask(path, sh, "run", "die")
+	assert.Equal(t, 143, code, "output: %s", out)
+}
+
+// runs stask with the staskfile at path and shell as its shell, returns its exit code and output
+func runStask(path string, shell string, args ...string) (int, string) {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "STASK_TEST_MAIN=1", "STASKFILE_PATH="+path, "SHELL="+shell,
+		"STASK_SHELL=", "STASK_SHELL_FLAGS=-c", "STASK_EXEC=", "NO_COLOR=1")
+	out, err := cmd.CombinedOutput()
+	var exerr *exec.ExitError
+	if errors.As(err, &exerr) {
+		return exerr.ExitCode(), string(out)
+	}
+	if err != nil {
+		return -1, err.Error()
+	}
+	return 0, string(out)
+}
+
+func TestExitCodes(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not installed")
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "staskfile.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"Version": 1, "Tasks": {
+    "ok": "exit 0",
+    "fail": "exit 3",
+    "template": "echo {x",
+    "state": "echo {x}"
+}}`), 0644))
+	invalid := filepath.Join(dir, "invalid.json")
+	assert.Nil(t, os.WriteFile(invalid, []byte(`{"Tasks": {`), 0644))
+
+	var tests = []struct {
+		name  string
+		path  string
+		shell string
+		args  []string
+		code  int
+	}{
+		{"Ok", path, sh, []string{"run", "ok"}, 0},
+		{"TaskExitCode", path, sh, []string{"run", "fail"}, 3},
+		{"UnknownTask", path, sh, []string{"run", "missing"}, exitUsage},
+		{"UnknownCommand", path, sh, []string{"bogus"}, exitUsage},
+		{"InvalidStaskfile", invalid, sh, []string{"run", "ok"}, exitInvalid},
+		{"MissingStaskfile", filepath.Join(dir, "missing.json"), sh, []string{"run", "ok"}, exitNotFound},
+		{"BadTemplate", path, sh, []string{"run", "template"}, exitTemplate},
+		{"MissingState", path, sh, []string{"run", "state"}, exitMissingState},
+		{"NoShell", path, "", []string{"run", "ok"}, exitShell},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, out := runStask(tt.path, tt.shell, tt.args...)
+			assert.Equal(t, tt.code, code, "output: %s", out)
+		})
+	}
+}
+
+func TestWithoutInteractiveFlag(t *testing.T) {
+	var tests = []struct {
+		flags    []string
+		expected []string
+	}{
+		{[]string{"-ic"}, []string{"-c"}},
+		{[]string{"-lic"}, []string{"-lc"}},
+		{[]string{"-i", "-c"}, []string{"-c"}},
+		{[]string{"--interactive", "-c"}, []string{"-c"}},
+		{[]string{"-c"}, []string{"-c"}},
+		// flags of other shells are not clusters of single letters
+		{[]string{"-NoProfile", "-NonInteractive", "-Command"}, []string{"-NoProfile", "-NonInteractive", "-Command"}},
+		{[]string{"-ExecutionPolicy", "Bypass", "-c"}, []string{"-ExecutionPolicy", "Bypass", "-c"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.flags, " "), func(t *testing.T) {
+			assert.Equal(t, tt.expected, withoutInteractiveFlag(tt.flags))
+		})
+	}
+}
+
+func TestParseRunArgs(t *testing.T) {
+	var tests = []struct {
+		name      string
+		args      []string
+		overrides map[string]string
+		fwd       []string
+		err       string
+	}{
+		{"Task", []string{"b"}, map[string]string{}, nil, ""},
+		{"Set", []string{"b", "-s", "env=prod"}, map[string]string{"env": "prod"}, nil, ""},
+		{"LongSet", []string{"b", "--set", "env=prod"}, map[string]string{"env": "prod"}, nil, ""},
+		{"SetEquals", []string{"b", "--set=env=prod"}, map[string]string{"env": "prod"}, nil, ""},
+		{"Bare", []string{"b", "env=prod", "v=1"}, map[string]string{"env": "prod", "v": "1"}, nil, ""},
+		{"EmptyValue", []string{"b", "env="}, map[string]string{"env": ""}, nil, ""},
+		{"Fwd", []string{"b", "env=prod", "--", "-x", "env=staging"}, map[string]string{"env": "prod"}, []string{"-x", "env=staging"}, ""},
+		{"MissingValue", []string{"b", "-s"}, nil, nil, "missing key=value after '-s'"},
+		{"MissingKey", []string{"b", "--set==prod"}, nil, nil, "expected key=value, got '=prod'"},
+		{"NotPair", []string{"b", "-s", "env"}, nil, nil, "expected key=value, got 'env'"},
+		{"Unexpected", []string{"b", "build"}, nil, nil, "unexpected argument 'build'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var usageErr string
+			run := func() (string, map[string]string, []string) {
+				// the usage error exits stask, stop parsing like it would
+				defer func() {
+					if r := recover(); r != nil && r != "exit" {
+						panic(r)
+					}
+				}()
+				return parseRunArgs(append([]string{"stask", "run"}, tt.args...), func(message string) {
+					usageErr = message
+					panic("exit")
+				})
+			}
+
+			key, overrides, fwd := run()
+			assert.Equal(t, tt.err, usageErr)
+			if len(tt.err) == 0 {
+				assert.Equal(t, "b", key)
+				assert.Equal(t, tt.overrides, overrides)
+				assert.Equal(t, tt.fwd, fwd)
+			}
+		})
+	}
+}
+
+func TestTakeFwdOverrides(t *testing.T) {
+	sf := staskfile.Staskfile{
+		Tasks: map[string]staskfile.Task{
+			"deploy": {Cmd: "deploy --env {env} {args}", Deps: []string{"build"}},
+			"build":  {Cmd: "make", Env: map[string]string{"OUT": "{out:-bin}"}},
+			"all":    {Parallel: []string{"deploy"}},
+			"other":  {Cmd: "{if verbose}echo {end}make"},
+		},
+		State: map[string]string{"region": "us", "host": "{region}.{domain}"},
+	}
+	var tests = []struct {
+		name      string
+		plan      []string
+		fwd       []string
+		overrides map[string]string
+		left      []string
+	}{
+		{"UsedKey", []string{"deploy"}, []string{"env=staging"}, map[string]string{"env": "staging"}, []string{}},
+		{"UnusedKey", []string{"deploy"}, []string{"CC=clang"}, map[string]string{}, []string{"CC=clang"}},
+		{"DepKey", []string{"build", "deploy"}, []string{"out=dist", "env=staging", "-v"}, map[string]string{"out": "dist", "env": "staging"}, []string{"-v"}},
+		{"ParallelKey", []string{"all"}, []string{"env=staging"}, map[string]string{"env": "staging"}, []string{}},
+		{"CondKey", []string{"other"}, []string{"verbose=1"}, map[string]string{"verbose": "1"}, []string{}},
+		{"StateKey", []string{"other"}, []string{"region=eu"}, map[string]string{"region": "eu"}, []string{}},
+		{"StateValueKey", []string{"build"}, []string{"domain=local"}, map[string]string{"domain": "local"}, []string{}},
+		{"StopsAtUnused", []string{"deploy"}, []string{"CC=clang", "env=staging"}, map[string]string{}, []string{"CC=clang", "env=staging"}},
+		{"AfterArg", []string{"deploy"}, []string{"-v", "env=staging"}, map[string]string{}, []string{"-v", "env=staging"}},
+		{"OtherTask", []string{"build"}, []string{"env=staging"}, map[string]string{}, []string{"env=staging"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			overrides := map[string]string{}
+			left := takeFwdOverrides(sf, tt.plan, tt.fwd, overrides)
+			assert.Equal(t, tt.overrides, overrides)
+			assert.Equal(t, tt.left, left)
+		})
+	}
+}